@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+const applySystemPrompt = "You rewrite source files. Answer only with the complete rewritten file " +
+	"in a single fenced code block, without explanations and without omitting any part of the file."
+
+func applyCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "apply",
+		Usage: "Rewrite each file of a scope with a prompt and review the changes before writing them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "prompt",
+				Usage:   "The changes to make, it can be a Go text/template using {{.Path}}, {{.Content}}, {{.Diff}}, etc",
+				Aliases: []string{"p"},
+			},
+			&cli.StringFlag{
+				Name:    "template",
+				Usage:   "Read the prompt template from a file",
+				Aliases: []string{"t"},
+			},
+			&cli.StringFlag{
+				Name:    "use",
+				Usage:   "Use a prompt from the prompt library",
+				Aliases: []string{"u"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "context",
+				Usage: "Files sent as context before each file, in addition to the contextFiles of the scope",
+			},
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the files to the provider instead of redacting them",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "Continue with the next file when a file fails instead of aborting the run",
+			},
+			&cli.Float64Flag{
+				Name:  "budget",
+				Usage: "Maximum spend in USD of the run, defaults to the budget of coderunner/config.json",
+			},
+			&cli.IntFlag{
+				Name:  "max-tokens-total",
+				Usage: "Maximum tokens used by the run, defaults to the maxTokensTotal of coderunner/config.json",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.applyCmd"
+
+			selectedScope, err := scopes.LoadSelectedScope()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			options, err := runOptionsFromFlags(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			options.Mode = scopes.ModeApply
+			options.System = strings.TrimSpace(options.System + "\n\n" + applySystemPrompt)
+
+			run, err := scopes.NewRun(selectedScope, options)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			return executeRun(run)
+		},
+	}
+}
+
+// applyCallback creates a callback that shows the diff of the rewritten file
+// and lets the user accept, reject or edit it before writing it
+func applyCallback() scopes.LLMCallback {
+	reader := bufio.NewReader(os.Stdin)
+
+	return func(file *scopes.RunFile) error {
+		const op = "applyCallback"
+
+		if err := checkTruncated(file); err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		content, err := extractRewrittenFile(file.Response)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		for {
+			diff, err := diffContent(file.Path, content)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if diff == "" {
+				fmt.Printf("No changes for %s\n", file.Path)
+				return nil
+			}
+
+			fmt.Print(diff)
+			fmt.Printf("Apply changes to %s? [a]ccept, [r]eject, [e]dit: ", file.Path)
+
+			answer, err := reader.ReadString('\n')
+			if err == io.EOF {
+				fmt.Println("\nNo answer, rejected")
+				return nil
+			} else if err != nil {
+				return ez.New(op, ez.EINTERNAL, "Error reading answer", err)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "a", "accept":
+				return writeRewrittenFile(file, content)
+			case "r", "reject":
+				fmt.Println("Rejected")
+				return nil
+			case "e", "edit":
+				content, err = editContent(file.Path, content)
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+			}
+		}
+	}
+}
+
+// extractRewrittenFile returns the largest code block of the response
+func extractRewrittenFile(response string) (string, error) {
+	const op = "cli.extractRewrittenFile"
+
+	blocks := extract.CodeBlocks(response)
+	if len(blocks) == 0 {
+		return "", ez.New(op, ez.EINVALID, "No code block found in the response", nil)
+	}
+
+	largest := blocks[0]
+	for _, block := range blocks[1:] {
+		if len(block.Code) > len(largest.Code) {
+			largest = block
+		}
+	}
+
+	return largest.Code + "\n", nil
+}
+
+// diffContent returns the colored diff between a file and its new content
+func diffContent(path, content string) (string, error) {
+	const op = "cli.diffContent"
+
+	tmpPath, err := writeTempFile(path, content)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+	defer os.Remove(tmpPath)
+
+	diff, err := git.DiffFiles(path, tmpPath, true)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	// Show the path of the file instead of the temporary one
+	return strings.ReplaceAll(diff, strings.TrimPrefix(tmpPath, "/"), path), nil
+}
+
+// editContent opens the new content of a file in the editor and returns it
+// with the user changes
+func editContent(path, content string) (string, error) {
+	const op = "cli.editContent"
+
+	tmpPath, err := writeTempFile(path, content)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+	defer os.Remove(tmpPath)
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	if err := files.OpenFile(tmpPath, editor); err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	edited, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error reading edited file", err)
+	}
+
+	return string(edited), nil
+}
+
+// writeRewrittenFile writes the new content of a file, refusing to do it if the
+// file changed since its content was sent to the LLM
+func writeRewrittenFile(file *scopes.RunFile, content string) error {
+	const op = "cli.writeRewrittenFile"
+
+	current, err := os.ReadFile(file.Path)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to read file: "+file.Path, err)
+	}
+
+	hash := sha256.Sum256(current)
+	if hex.EncodeToString(hash[:]) != file.ContentHash {
+		errMsg := fmt.Sprintf("File %s changed since the request was sent, refusing to overwrite it", file.Path)
+		return ez.Root(op, ez.ECONFLICT, errMsg)
+	}
+
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to stat file: "+file.Path, err)
+	}
+
+	if err := os.WriteFile(file.Path, []byte(content), info.Mode()); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to write file: "+file.Path, err)
+	}
+
+	fmt.Printf("Written %s\n", file.Path)
+
+	return nil
+}
+
+// writeTempFile writes content to a temporary file with the same extension as
+// path so editors and diffs treat it the same way
+func writeTempFile(path, content string) (string, error) {
+	const op = "cli.writeTempFile"
+
+	tmp, err := os.CreateTemp("", "coderunner-*"+filepath.Ext(path))
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error creating temporary file", err)
+	}
+	defer tmp.Close()
+
+	if _, err := tmp.WriteString(content); err != nil {
+		os.Remove(tmp.Name())
+		return "", ez.New(op, ez.EINTERNAL, "Error writing temporary file", err)
+	}
+
+	return tmp.Name(), nil
+}
+
+// checkTruncated refuses responses cut at the max output tokens, writing them
+// would leave incomplete files
+func checkTruncated(file *scopes.RunFile) error {
+	const op = "cli.checkTruncated"
+
+	if file.Truncated {
+		errMsg := fmt.Sprintf("Response for %s was cut at the max output tokens of the model, it is not applied", file.Path)
+		return ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+
+	return nil
+}
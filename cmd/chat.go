@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/redact"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+const chatHelp = `Commands:
+  /add <path>    Add a file to the context
+  /drop <path>   Remove a file from the context
+  /files         List the files in the context
+  /model [name]  Show or change the model
+  /tokens        Show the estimated tokens of the next request and the usage so far
+  /save          Write the transcript as markdown
+  /exit          End the chat`
+
+func ChatCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "chat",
+		Usage: "Ask questions about the files of the selected scope",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the files to the provider instead of redacting them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.ChatCmd"
+
+			selectedScope, err := scopes.LoadSelectedScope()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			// The whole conversation shares a redactor, also across /model
+			redactor, err := commandRedactor(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			api, err := scopes.NewLLM(c.String("model"), redactor)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			chat, err := scopes.NewChat(selectedScope, c.String("model"))
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+			chat.NoRedact = c.Bool("no-redact")
+
+			fmt.Printf("Chat %s on scope [%s] with %d files, /help for commands\n", chat.ID, selectedScope.Name, len(chat.Files))
+
+			reader := bufio.NewReader(os.Stdin)
+			for {
+				fmt.Print("> ")
+
+				line, err := reader.ReadString('\n')
+				if err == io.EOF {
+					fmt.Println()
+					return nil
+				} else if err != nil {
+					return ez.New(op, ez.EINTERNAL, "Error reading input", err)
+				}
+
+				line = strings.TrimSpace(line)
+				if line == "" {
+					continue
+				}
+
+				if strings.HasPrefix(line, "/") {
+					done, err := chatCommand(chat, &api, redactor, line)
+					if err != nil {
+						color.Red(ez.ErrorMessage(err))
+					}
+					if done {
+						return nil
+					}
+					continue
+				}
+
+				response, err := chat.Ask(api, line)
+				if err != nil {
+					color.Red(ez.ErrorMessage(err))
+					continue
+				}
+				fmt.Printf("\n%s\n\n", strings.TrimSpace(response.Content))
+
+				if err := chat.Save(); err != nil {
+					return ez.Wrap(op, err)
+				}
+			}
+		},
+	}
+}
+
+// chatCommand runs a slash command of the chat, it returns true when the chat
+// must end
+func chatCommand(chat *scopes.Chat, api *llm.API, redactor *redact.Redactor, line string) (bool, error) {
+	const op = "cli.chatCommand"
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch command {
+	case "/add", "/drop":
+		if arg == "" {
+			return false, ez.New(op, ez.EINVALID, fmt.Sprintf("Usage: %s <path>", command), nil)
+		}
+
+		var err error
+		if command == "/add" {
+			err = chat.AddFile(arg)
+		} else {
+			err = chat.DropFile(arg)
+		}
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+
+		fmt.Printf("%d files in the context\n", len(chat.Files))
+
+	case "/files":
+		for _, path := range chat.Files {
+			fmt.Println(path)
+		}
+
+	case "/model":
+		if arg == "" {
+			fmt.Printf("Model: %s, available: %s\n", chat.Model, strings.Join(llm.ModelNames(), ", "))
+			return false, nil
+		}
+
+		newAPI, err := scopes.NewLLM(arg, redactor)
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+
+		*api = newAPI
+		chat.Model = arg
+		fmt.Printf("Model: %s\n", chat.Model)
+
+	case "/tokens":
+		model, err := llm.LookupModel(chat.Model)
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+
+		tokens := model.EstimateRequestTokens(chat.Request(""))
+		fmt.Printf("Next request: ~%d tokens of a %d tokens context window, %d files, %d messages\n",
+			tokens, model.ContextWindow, len(chat.Files), len(chat.Messages))
+		fmt.Printf("Used: %d input tokens, %d output tokens, $%.4f\n",
+			chat.Usage.InputTokens+chat.Usage.CacheReadTokens+chat.Usage.CacheWriteTokens,
+			chat.Usage.OutputTokens, model.Cost(chat.Usage))
+
+	case "/save":
+		path, err := chat.SaveTranscript()
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+		fmt.Printf("Transcript written to: %s\n", path)
+
+	case "/help":
+		fmt.Println(chatHelp)
+
+	case "/exit", "/quit":
+		return true, nil
+
+	default:
+		return false, ez.New(op, ez.EINVALID, fmt.Sprintf("Unknown command %s, /help for commands", command), nil)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+// MaxCommitDiffChars is the maximum size of the diff sent to generate a commit
+// message, larger diffs are truncated
+const MaxCommitDiffChars = 100000
+
+// commitLogSubjects is the amount of recent commit subjects sent as examples
+// of the style of the repository
+const commitLogSubjects = 15
+
+const commitMsgSystemPrompt = "You write git commit messages. Answer only with the commit message, without " +
+	"code fences or explanations: a subject line of at most 72 characters in the imperative mood, then " +
+	"optionally a blank line and a body wrapped at 72 characters explaining what changed and why."
+
+const conventionalCommitsPrompt = "The subject must follow Conventional Commits: <type>(<optional scope>): " +
+	"<description>, where type is one of feat, fix, docs, style, refactor, perf, test, build, ci, chore or revert. " +
+	"Add a BREAKING CHANGE: footer if the change breaks compatibility."
+
+// hookMarker identifies the prepare-commit-msg hooks installed by coderunner
+const hookMarker = "# Installed by coderunner commit-msg install-hook"
+
+func CommitMsgCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "commit-msg",
+		Usage: "Generate a commit message from the staged changes",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "base",
+				Usage: "Describe the changes from this commit to HEAD instead of the staged changes",
+			},
+			&cli.BoolFlag{
+				Name:  "scope",
+				Usage: "Describe the changes from the base commit of the selected scope to HEAD",
+			},
+			&cli.BoolFlag{
+				Name:  "conventional",
+				Usage: "Follow the Conventional Commits format",
+			},
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Usage:   "Write the message at the start of this file instead of printing it",
+				Aliases: []string{"o"},
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the diff to the provider instead of redacting them",
+			},
+		},
+		Subcommands: []*cli.Command{
+			commitMsgInstallHookCmd(),
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.CommitMsgCmd"
+
+			base := c.String("base")
+			if c.Bool("scope") {
+				selectedScope, err := scopes.LoadSelectedScope()
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+
+				if selectedScope.BaseCommit == "" {
+					return ez.New(op, ez.EINVALID, "The selected scope has no base commit, create it with scope create --base", nil)
+				}
+				base = selectedScope.BaseCommit
+			}
+
+			var diff string
+			var err error
+			if base != "" {
+				diff, err = git.GetDiff(base, "HEAD")
+			} else {
+				diff, err = git.GetStagedDiff()
+			}
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if strings.TrimSpace(diff) == "" {
+				return ez.New(op, ez.EINVALID, "There are no changes to describe, stage them first", nil)
+			}
+
+			projectPolicy, err := policy.Load()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if err := projectPolicy.CheckDiff(diff); err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			redactor, err := commandRedactor(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			api, err := scopes.NewLLM(c.String("model"), redactor)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			message, err := generateCommitMessage(api, diff, c.Bool("conventional"))
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if c.String("output") == "" {
+				fmt.Println(message)
+				return nil
+			}
+
+			return prependToFile(c.String("output"), message+"\n")
+		},
+	}
+}
+
+func commitMsgInstallHookCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "install-hook",
+		Usage: "Install a prepare-commit-msg hook so git commit opens with a draft message",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "conventional",
+				Usage: "Follow the Conventional Commits format",
+			},
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Overwrite an existing prepare-commit-msg hook",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.commitMsgInstallHookCmd"
+
+			hooksPath, err := git.GetHooksPath()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			hookPath := filepath.Join(hooksPath, "prepare-commit-msg")
+
+			existing, err := os.ReadFile(hookPath)
+			if err == nil && !strings.Contains(string(existing), hookMarker) && !c.Bool("force") {
+				errMsg := fmt.Sprintf("A prepare-commit-msg hook already exists at %s, use --force to overwrite it", hookPath)
+				return ez.New(op, ez.ECONFLICT, errMsg, nil)
+			}
+
+			args := "--model " + c.String("model")
+			if c.Bool("conventional") {
+				args += " --conventional"
+			}
+
+			// Only draft a message for a plain git commit, not with -m, -F,
+			// templates, merges, squashes or amends
+			hook := fmt.Sprintf(`#!/bin/sh
+%s
+if [ -z "$2" ]; then
+	coderunner commit-msg %s --output "$1" || true
+fi
+`, hookMarker, args)
+
+			if err := os.MkdirAll(hooksPath, os.ModePerm); err != nil {
+				return ez.New(op, ez.EINTERNAL, "Error creating hooks directory", err)
+			}
+
+			if err := os.WriteFile(hookPath, []byte(hook), 0755); err != nil {
+				return ez.New(op, ez.EINTERNAL, "Error writing hook", err)
+			}
+
+			fmt.Printf("Hook installed at %s\n", hookPath)
+
+			return nil
+		},
+	}
+}
+
+// generateCommitMessage asks the LLM for the commit message of a diff following
+// the style of the recent commits of the repository
+func generateCommitMessage(api llm.API, diff string, conventional bool) (string, error) {
+	const op = "cli.generateCommitMessage"
+
+	system := commitMsgSystemPrompt
+	if conventional {
+		system += " " + conventionalCommitsPrompt
+	}
+
+	subjects, err := git.GetLogSubjects(commitLogSubjects)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	var prompt strings.Builder
+	if len(subjects) > 0 {
+		prompt.WriteString("Match the style of the recent commit subjects of the repository:\n")
+		for _, subject := range subjects {
+			fmt.Fprintf(&prompt, "- %s\n", subject)
+		}
+		prompt.WriteString("\n")
+	}
+
+	diff = scopes.Truncate(diff, MaxCommitDiffChars)
+	fmt.Fprintf(&prompt, "Write the commit message for this diff:\n\n%s", diff)
+
+	fmt.Fprint(os.Stderr, "Calling LLM... ")
+	response, err := api.Send(llm.NewRequest(system, "", prompt.String()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed", err)
+		return "", ez.Wrap(op, err)
+	}
+	fmt.Fprintln(os.Stderr, "Ok")
+
+	message := strings.TrimSpace(response.Content)
+
+	// Some models wrap the message in a code block anyway
+	if blocks := extract.CodeBlocks(message); len(blocks) == 1 && strings.HasPrefix(message, "```") {
+		message = strings.TrimSpace(blocks[0].Code)
+	}
+
+	return message, nil
+}
+
+// prependToFile writes text before the current content of a file, keeping the
+// comments git adds to the commit message file
+func prependToFile(path, text string) error {
+	const op = "cli.prependToFile"
+
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return ez.New(op, ez.EINTERNAL, "Failed to read file: "+path, err)
+	}
+
+	if err := os.WriteFile(path, append([]byte(text), current...), 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to write file: "+path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+func compareCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "compare",
+		Usage:     "Print the report of a model comparison",
+		ArgsUsage: "<comparison id>",
+		Action: func(c *cli.Context) error {
+			const op = "cli.compareCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the ID of the comparison", nil)
+			}
+
+			runs, err := scopes.LoadComparison(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			fmt.Print(scopes.ComparisonReport(runs))
+
+			return nil
+		},
+	}
+}
+
+// executeComparison runs every model of a comparison one after the other and
+// prints the report comparing their responses. A failing model doesn't stop
+// the others
+func executeComparison(runs []*scopes.Run) error {
+	const op = "cli.executeComparison"
+
+	failed := 0
+	for _, run := range runs {
+		fmt.Fprintf(os.Stderr, "\nModel %s\n", run.Options.Model)
+
+		if err := executeRun(run); err != nil {
+			// Errors go to stderr so they never mix into the report
+			fmt.Fprintln(os.Stderr, color.RedString(ez.ErrorMessage(err)))
+			failed++
+		}
+	}
+
+	report := scopes.ComparisonReport(runs)
+
+	comparisonID := runs[0].Options.Comparison
+	outputPath := filepath.Join(files.GetOutDirPath(comparisonID), "comparison.md")
+	if err := runs[0].WriteOutput(outputPath, report); err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	fmt.Print(report)
+	fmt.Fprintf(os.Stderr, "\nComparison written to: %s\nShow it again with: coderunner llm compare %s\n", outputPath, comparisonID)
+
+	if failed == len(runs) {
+		return ez.New(op, ez.EINTERNAL, "Every model of the comparison failed", nil)
+	}
+
+	return nil
+}
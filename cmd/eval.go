@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/eval"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/llm/replay"
+	"github.com/vanclief/coderunner/redact"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+func EvalCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "eval",
+		Usage:     "Run an eval suite and compare its pass rate against the baseline",
+		ArgsUsage: "<suite file or name in coderunner/evals>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "Override the model of the suite",
+				Aliases: []string{"m"},
+			},
+			&cli.StringFlag{
+				Name:  "judge-model",
+				Usage: "Model that grades the judge assertions, defaults to the judgeModel of the suite or its model",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "Answer every request from a cassette file, requests that were not recorded fail",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "Record the responses in a cassette file to replay them later",
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the files of the suite to the provider instead of redacting them",
+			},
+			&cli.StringFlag{
+				Name:  "baseline",
+				Usage: "Baseline to compare against, defaults to <suite>.baseline.json",
+			},
+			&cli.BoolFlag{
+				Name:  "update-baseline",
+				Usage: "Save the result as the new baseline",
+			},
+			&cli.Float64Flag{
+				Name:  "min-pass-rate",
+				Usage: "Fail if the percentage of assertions that pass is lower",
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Usage:   "Amount of files sent to the LLM at the same time",
+				Aliases: []string{"j"},
+				Value:   1,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.EvalCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the eval suite", nil)
+			}
+
+			if c.String("replay") != "" && c.String("record") != "" {
+				return ez.New(op, ez.EINVALID, "Use only one of --replay or --record", nil)
+			}
+
+			suitePath, err := eval.Find(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			suite, err := eval.Load(suitePath)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			options, err := suite.RunOptions()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if c.IsSet("model") {
+				options.Model = c.String("model")
+			}
+			options.Concurrency = c.Int("concurrency")
+			options.NoRedact = c.Bool("no-redact")
+
+			judgeModel := c.String("judge-model")
+			if judgeModel == "" {
+				judgeModel = suite.JudgeModel
+			}
+			if judgeModel == "" {
+				judgeModel = options.Model
+			}
+
+			var cassette *replay.Cassette
+			cassettePath := c.String("replay") + c.String("record")
+			if cassettePath != "" {
+				if _, err := os.Stat(cassettePath); err != nil && c.IsSet("replay") {
+					errMsg := fmt.Sprintf("Cassette %s doesn't exist, record it with --record", cassettePath)
+					return ez.New(op, ez.ENOTFOUND, errMsg, err)
+				}
+
+				cassette, err = replay.LoadCassette(cassettePath)
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+			}
+
+			// The run redacts the requests of the files itself, the judge gets the
+			// responses which can contain the restored secrets
+			api, err := evalAPI(options.Model, cassette, c.IsSet("record"), nil)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			redactor, err := commandRedactor(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			judge, err := evalAPI(judgeModel, cassette, c.IsSet("record"), redactor)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			result, err := eval.Run(suite, options, api, judge)
+			if cassette != nil && c.IsSet("record") {
+				// Keep what was recorded even if the run stopped early
+				if saveErr := cassette.Save(); saveErr != nil {
+					return ez.Wrap(op, saveErr)
+				}
+				fmt.Fprintf(os.Stderr, "Responses recorded in: %s\n", cassettePath)
+			}
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			printEvalResult(result)
+
+			baselinePath := c.String("baseline")
+			if baselinePath == "" {
+				baselinePath = suite.BaselinePath()
+			}
+
+			regressions := 0
+			if _, err := os.Stat(baselinePath); err == nil || c.IsSet("baseline") {
+				baseline, err := eval.LoadResult(baselinePath)
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+
+				diff := result.Compare(baseline)
+				printEvalDiff(baseline, result, diff)
+				regressions = len(diff.Regressions)
+			} else {
+				fmt.Printf("\nNo baseline at %s, save one with --update-baseline\n", baselinePath)
+			}
+
+			if c.Bool("update-baseline") {
+				if err := result.Save(baselinePath); err != nil {
+					return ez.Wrap(op, err)
+				}
+				fmt.Printf("Baseline written to: %s\n", baselinePath)
+				return nil
+			}
+
+			if regressions > 0 {
+				errMsg := fmt.Sprintf("%d assertions regressed against the baseline", regressions)
+				return ez.New(op, ez.ECONFLICT, errMsg, nil)
+			}
+
+			if c.IsSet("min-pass-rate") && result.PassRate() < c.Float64("min-pass-rate") {
+				errMsg := fmt.Sprintf("The pass rate %.1f%% is lower than %.1f%%", result.PassRate(), c.Float64("min-pass-rate"))
+				return ez.New(op, ez.ECONFLICT, errMsg, nil)
+			}
+
+			return nil
+		},
+	}
+}
+
+// evalAPI returns the API of a model, when there is a cassette the responses
+// are replayed from it or recorded in it
+func evalAPI(model string, cassette *replay.Cassette, record bool, redactor *redact.Redactor) (llm.API, error) {
+	const op = "cli.evalAPI"
+
+	if cassette != nil && !record {
+		return replay.NewAPI(cassette, model, nil), nil
+	}
+
+	api, err := scopes.NewLLM(model, redactor)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if cassette != nil {
+		return replay.NewAPI(cassette, model, api), nil
+	}
+
+	return api, nil
+}
+
+func printEvalResult(result *eval.Result) {
+	for _, file := range result.Files {
+		fmt.Printf("==> %s\n", file.Path)
+		if file.Error != "" {
+			color.Red("Error: %s", file.Error)
+		}
+
+		for _, assertion := range file.Assertions {
+			if assertion.Passed {
+				color.Green("  PASS %s", assertion.Name)
+			} else if assertion.Message != "" {
+				color.Red("  FAIL %s: %s", assertion.Name, assertion.Message)
+			} else {
+				color.Red("  FAIL %s", assertion.Name)
+			}
+		}
+	}
+
+	passed, total := result.Count()
+	fmt.Printf("\nSuite %s with %s: %d/%d assertions passed (%.1f%%)\n",
+		result.Suite, result.Model, passed, total, result.PassRate())
+}
+
+func printEvalDiff(baseline, result *eval.Result, diff *eval.Diff) {
+	fmt.Printf("Baseline (%s, run %s): %.1f%%, now %.1f%%\n",
+		baseline.Model, baseline.RunID, baseline.PassRate(), result.PassRate())
+
+	for _, key := range diff.Regressions {
+		color.Red("  Regressed %s", key)
+	}
+	for _, key := range diff.Improvements {
+		color.Green("  Improved %s", key)
+	}
+	for _, key := range diff.Added {
+		fmt.Printf("  New %s\n", key)
+	}
+}
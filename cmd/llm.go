@@ -3,18 +3,39 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/config"
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/prompts"
+	"github.com/vanclief/coderunner/redact"
 	"github.com/vanclief/coderunner/scopes"
 	"github.com/vanclief/ez"
 )
 
+// dryRunUsage is the help of --dry-run, it states how rough the token counts are
+var dryRunUsage = fmt.Sprintf("Print the requests with their estimated tokens and cost without calling the LLM, "+
+	"tokens are a rough characters per token heuristic, not the model tokenizer, and can be off by ±%.0f%%", llm.EstimateMargin*100)
+
 func LLMCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "llm",
 		Usage: "Call a llm on each file of scope",
 		Subcommands: []*cli.Command{
 			promptCmd(),
+			applyCmd(),
+			testsCmd(),
+			resumeCmd(),
+			historyCmd(),
+			showCmd(),
+			compareCmd(),
 		},
 	}
 }
@@ -25,20 +46,119 @@ func promptCmd() *cli.Command {
 		Usage: "Run a prompt on each file of a scope",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "prompt",
-				Usage:    "The actual prompt",
-				Aliases:  []string{"p"},
-				Required: true,
+				Name:    "prompt",
+				Usage:   "The actual prompt, it can be a Go text/template using {{.Path}}, {{.Content}}, {{.Diff}}, etc",
+				Aliases: []string{"p"},
+			},
+			&cli.StringFlag{
+				Name:    "template",
+				Usage:   "Read the prompt template from a file",
+				Aliases: []string{"t"},
 			},
 			&cli.StringFlag{
+				Name:    "use",
+				Usage:   "Use a prompt from the prompt library",
+				Aliases: []string{"u"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "context",
+				Usage: "Files sent as context before each file, in addition to the contextFiles of the scope",
+			},
+			&cli.StringSliceFlag{
 				Name:    "model",
-				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet, ollama:<model>), repeat it to compare several models",
 				Aliases: []string{"m"},
-				Value:   "sonnet",
+				Value:   cli.NewStringSlice("sonnet"),
 			},
 			&cli.BoolFlag{
 				Name:  "save",
-				Usage: "Should the model save the response next to the file",
+				Usage: "Save the responses in the output directory instead of printing them",
+			},
+			&cli.StringFlag{
+				Name:  "out-dir",
+				Usage: "Directory where the responses are saved mirroring the scope, defaults to .coderunner/out/<run id>",
+			},
+			&cli.StringFlag{
+				Name:  "out-name",
+				Usage: "Template of the name of saved responses, using {{.Name}}, {{.Stem}}, {{.Ext}}, {{.RunID}} or {{.Model}}",
+				Value: scopes.DefaultOutName,
+			},
+			&cli.StringSliceFlag{
+				Name:  "include",
+				Usage: "Only run on the files of the scope matching a glob, ** matches any directories",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "Skip the files of the scope matching a glob, e.g. migrations/ or *_test.go",
+			},
+			&cli.StringFlag{
+				Name:  "changed-since",
+				Usage: "Only run on the files of the scope changed since a git ref, including uncommitted changes",
+			},
+			&cli.StringSliceFlag{
+				Name:  "extract",
+				Usage: "Keep only part of each response (code, code:<lang>, code-all, code-all:<lang>, json), repeat it to chain extracts",
+			},
+			&cli.StringSliceFlag{
+				Name:  "filter",
+				Usage: "Shell command each response is piped through after the extracts, the path of the file is in $CODERUNNER_FILE",
+			},
+			&cli.StringFlag{
+				Name:  "reduce-prompt",
+				Usage: "Prompt used to synthesize all the per-file responses into a single report",
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the files to the provider instead of redacting them",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "Continue with the next file when a file fails instead of aborting the run",
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Usage:   "Amount of files sent to the LLM at the same time",
+				Aliases: []string{"j"},
+				Value:   1,
+			},
+			&cli.StringFlag{
+				Name:  "patch",
+				Usage: "Ask for a unified diff per file and apply, stage or save it (apply, stage, save)",
+			},
+			&cli.IntFlag{
+				Name:  "patch-retries",
+				Usage: "Times a patch that fails to apply is sent back to the LLM to be fixed",
+				Value: DefaultPatchRetries,
+			},
+			&cli.BoolFlag{
+				Name:  "diff",
+				Usage: "Send the git diff of each file instead of its content",
+			},
+			&cli.IntFlag{
+				Name:  "diff-context",
+				Usage: "Lines of context around each diff hunk",
+				Value: scopes.DefaultDiffContext,
+			},
+			&cli.BoolFlag{
+				Name:  "diff-full",
+				Usage: "Send the full new file next to its diff",
+			},
+			&cli.Float64Flag{
+				Name:  "budget",
+				Usage: "Maximum spend in USD of the run, defaults to the budget of coderunner/config.json",
+			},
+			&cli.IntFlag{
+				Name:  "max-tokens-total",
+				Usage: "Maximum tokens used by the run, defaults to the maxTokensTotal of coderunner/config.json",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format of the responses (text, json, jsonl, markdown), sarif reviews the files for findings",
+				Value: scopes.FormatText,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: dryRunUsage,
 			},
 		},
 		Action: func(c *cli.Context) error {
@@ -49,36 +169,665 @@ func promptCmd() *cli.Command {
 				return ez.Wrap(op, err)
 			}
 
-			callback := llmCallback(c.Bool("save"))
+			options, err := runOptionsFromFlags(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
 
-			api, err := scopes.NewLLM(c.String("model"))
+			models := c.StringSlice("model")
+			if len(models) > 1 {
+				if options.Mode != scopes.ModePrompt || options.ReducePrompt != "" || !isTextFormat(options.Format) {
+					errMsg := "Comparing models doesn't support --patch, --reduce-prompt or --format, the comparison is a markdown report"
+					return ez.New(op, ez.EINVALID, errMsg, nil)
+				}
+
+				runs, err := scopes.NewComparison(selectedScope, options, models)
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+
+				if c.Bool("dry-run") {
+					for _, run := range runs {
+						if err := dryRun(run); err != nil {
+							return ez.Wrap(op, err)
+						}
+						fmt.Println()
+					}
+					return nil
+				}
+
+				return executeComparison(runs)
+			}
+
+			run, err := scopes.NewRun(selectedScope, options)
 			if err != nil {
 				return ez.Wrap(op, err)
 			}
 
-			selectedScope.RunPromptOnFiles(api, c.String("prompt"), callback)
+			if c.Bool("dry-run") {
+				return dryRun(run)
+			}
+
+			return executeRun(run)
+		},
+	}
+}
+
+func resumeCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "resume",
+		Usage:     "Retry the unfinished files of a previous run",
+		ArgsUsage: "<run id>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "Continue with the next file when a file fails instead of aborting the run",
+			},
+			&cli.Float64Flag{
+				Name:  "budget",
+				Usage: "Raise or lower the maximum spend in USD of the run",
+			},
+			&cli.IntFlag{
+				Name:  "max-tokens-total",
+				Usage: "Raise or lower the maximum tokens used by the run",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format of the responses (text, json, jsonl, markdown, sarif)",
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Usage:   "Amount of files sent to the LLM at the same time",
+				Aliases: []string{"j"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.resumeCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the ID of the run to resume", nil)
+			}
+
+			run, err := scopes.LoadRun(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
 
+			if run.IsFinished() {
+				return ez.New(op, ez.EINVALID, fmt.Sprintf("Run %s has no unfinished files", run.ID), nil)
+			}
+
+			if c.IsSet("keep-going") {
+				run.Options.KeepGoing = c.Bool("keep-going")
+			}
+			if c.IsSet("budget") {
+				run.Options.Budget = c.Float64("budget")
+			}
+			if c.IsSet("max-tokens-total") {
+				run.Options.MaxTokensTotal = c.Int("max-tokens-total")
+			}
+			if c.IsSet("concurrency") {
+				run.Options.Concurrency = c.Int("concurrency")
+			}
+			if c.IsSet("format") {
+				format := c.String("format")
+				if err := validateFormat(format); err != nil {
+					return ez.Wrap(op, err)
+				}
+
+				// The review prompt is only part of the run when it started as sarif
+				if format != run.Options.Format && (format == scopes.FormatSARIF || run.Options.Format == scopes.FormatSARIF) {
+					return ez.New(op, ez.EINVALID, "The format of a run can't be changed from or to sarif", nil)
+				}
+				run.Options.Format = format
+			}
+
+			run.ResetFailed()
+
+			return executeRun(run)
+		},
+	}
+}
+
+func historyCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "List the previous runs",
+		Action: func(c *cli.Context) error {
+			const op = "cli.historyCmd"
+
+			runs, err := scopes.ListRuns()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if len(runs) == 0 {
+				fmt.Println("No runs found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tDATE\tSCOPE\tCOMMIT\tMODEL\tDONE\tFAILED\tTOKENS")
+			for _, run := range runs {
+				usage := run.TotalUsage()
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d/%d\t%d\t%d\n",
+					run.ID,
+					run.CreatedAt.Format("2006-01-02 15:04"),
+					run.Scope.Name,
+					run.Commit,
+					run.Options.Model,
+					run.Count(scopes.FileDone),
+					len(run.Files),
+					run.Count(scopes.FileFailed),
+					usage.InputTokens+usage.OutputTokens,
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func showCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "Show the details of a previous run",
+		ArgsUsage: "<run id>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Usage:   "Show the prompt hash, usage, timings and response of a single file",
+				Aliases: []string{"f"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.showCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the ID of the run to show", nil)
+			}
+
+			run, err := scopes.LoadRun(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if c.String("file") != "" {
+				file, err := run.GetFile(c.String("file"))
+				if err != nil {
+					return ez.Wrap(op, err)
+				}
+
+				printRunFile(file)
+				return nil
+			}
+
+			printRun(run)
 			return nil
 		},
 	}
 }
 
-// llmCallback creates a callback function
-func llmCallback(save bool) func(string, string) error {
-	return func(path string, response string) error {
+// printRun prints the parameters of a run and the status of each of its files
+func printRun(run *scopes.Run) {
+	usage := run.TotalUsage()
+
+	fmt.Printf("Run: %s\n", run.ID)
+	fmt.Printf("Date: %s\n", run.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("Commit: %s\n", run.Commit)
+	if run.Scope.BaseCommit == "" {
+		fmt.Printf("Scope: [%s] Commit: %s\n", run.Scope.Name, run.Scope.TargetCommit)
+	} else {
+		fmt.Printf("Scope: [%s] Base: %s Target: %s\n", run.Scope.Name, run.Scope.BaseCommit, run.Scope.TargetCommit)
+	}
+	fmt.Printf("Model: %s\n", run.Options.Model)
+	if run.Options.PromptName != "" {
+		fmt.Printf("Prompt name: %s\n", run.Options.PromptName)
+	}
+	if run.Options.System != "" {
+		fmt.Printf("System: %s\n", run.Options.System)
+	}
+	if contextFiles := append(append([]string{}, run.Scope.ContextFiles...), run.Options.ContextFiles...); len(contextFiles) > 0 {
+		fmt.Printf("Context files: %s\n", strings.Join(contextFiles, ", "))
+	}
+	fmt.Printf("Prompt: %s\n", run.Options.Prompt)
+	if run.Options.ReducePrompt != "" {
+		fmt.Printf("Reduce prompt: %s\n", run.Options.ReducePrompt)
+	}
+	fmt.Printf("Usage: %d input tokens, %d output tokens\n\n", usage.InputTokens, usage.OutputTokens)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tFILE\tTOKENS\tDURATION\tPROMPT HASH")
+	for _, file := range run.Files {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			file.Status,
+			file.Path,
+			file.Usage.InputTokens+file.Usage.OutputTokens,
+			time.Duration(file.DurationMs)*time.Millisecond,
+			shortHash(file.PromptHash),
+		)
+	}
+	w.Flush()
+}
+
+// printRunFile prints everything recorded for a file of a run
+func printRunFile(file *scopes.RunFile) {
+	fmt.Printf("File: %s\n", file.Path)
+	fmt.Printf("Status: %s\n", file.Status)
+	if file.Model != "" {
+		fmt.Printf("Model: %s\n", file.Model)
+	}
+	if file.PromptHash != "" {
+		fmt.Printf("Prompt hash: %s\n", file.PromptHash)
+	}
+	if !file.StartedAt.IsZero() {
+		fmt.Printf("Started: %s\n", file.StartedAt.Format(time.RFC3339))
+		fmt.Printf("Duration: %s\n", time.Duration(file.DurationMs)*time.Millisecond)
+	}
+	fmt.Printf("Usage: %d input tokens, %d output tokens\n", file.Usage.InputTokens, file.Usage.OutputTokens)
+	if len(file.Redactions) > 0 {
+		fmt.Printf("Redacted: %s\n", redact.Summary(file.Redactions))
+	}
+	if file.Error != "" {
+		fmt.Printf("Error: %s\n", file.Error)
+	}
+	if file.Response != "" {
+		fmt.Printf("Response:\n%s\n", file.Response)
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// dryRun prints every request of a run with its estimated tokens and cost
+func dryRun(run *scopes.Run) error {
+	const op = "cli.dryRun"
+
+	model, err := llm.LookupModel(run.Options.Model)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	report, err := run.Scope.DryRun(run, model)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	printedContext := false
+	for _, file := range report.Files {
+		fmt.Printf("==> %s\n", file.Path)
+
+		switch {
+		case file.Error != "":
+			color.Red("Error: %s\n\n", file.Error)
+			continue
+		case file.Skipped:
+			fmt.Printf("Skipped binary file\n\n")
+			continue
+		}
+
+		if file.Request.System != "" {
+			fmt.Printf("System:\n%s\n\n", file.Request.System)
+		}
+		if file.Request.Context != "" {
+			if !printedContext {
+				fmt.Printf("Context (sent before every prompt):\n%s\n", file.Request.Context)
+				printedContext = true
+			} else {
+				fmt.Printf("Context: same as above\n\n")
+			}
+		}
+		for _, message := range file.Request.Messages {
+			fmt.Printf("%s:\n%s\n\n", strings.ToUpper(message.Role[:1])+message.Role[1:], message.Content)
+		}
+	}
+
+	fmt.Printf("Dry run with %s (%s)\n", model.Name, model.ID)
+	fmt.Printf("Token counts are a rough heuristic of %.1f characters per token, not the tokenizer of the model, and can be off by ±%.0f%%.\n",
+		model.CharsPerToken, llm.EstimateMargin*100)
+	fmt.Printf("Before the run starts, --budget and --max-tokens-total are checked against the lower estimate minus %.0f%%.\n\n",
+		llm.EstimateMargin*100)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tINPUT TOKENS (~)\tNOTE")
+	for _, file := range report.Files {
+		note := ""
+		switch {
+		case file.Error != "":
+			note = "error"
+		case file.Skipped:
+			note = "skipped"
+		case file.ExceedsContext:
+			note = fmt.Sprintf("exceeds the %d tokens context window", model.ContextWindow)
+		}
+		if len(file.Redactions) > 0 {
+			note = strings.TrimPrefix(note+", redacted "+redact.Summary(file.Redactions), ", ")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", file.Path, file.InputTokens, note)
+	}
+	w.Flush()
+
+	minOutput, maxOutput := report.OutputCostRange()
+
+	fmt.Printf("\nTotal input: ~%d tokens, estimated cost $%.4f\n", report.InputTokens, report.InputCost())
+	fmt.Printf("Estimated output: %d to %d tokens, $%.4f to $%.4f\n",
+		report.MinOutputTokens, report.MaxOutputTokens, minOutput, maxOutput)
+	fmt.Printf("Estimated total: $%.4f to $%.4f, ±%.0f%% for the token heuristic\n",
+		report.InputCost()+minOutput, report.InputCost()+maxOutput, llm.EstimateMargin*100)
+
+	if count := report.CountExceedingContext(); count > 0 {
+		color.Yellow("%d files exceed the context window of %s", count, model.Name)
+	}
+	if run.Options.ReducePrompt != "" || run.Options.Mode == scopes.ModePatch {
+		fmt.Println("Reduce calls and patch retries are not included in the estimate")
+	}
+
+	return nil
+}
+
+// commandRedactor returns the redactor of a command, nil with --no-redact
+func commandRedactor(c *cli.Context) (*redact.Redactor, error) {
+	const op = "cli.commandRedactor"
+
+	if c.Bool("no-redact") {
+		return nil, nil
+	}
+
+	redactor, err := scopes.NewRedactor()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return redactor, nil
+}
+
+// executeRun processes the unfinished files of a run and prints its summary
+func executeRun(run *scopes.Run) error {
+	const op = "cli.executeRun"
+
+	// Patch retries, test fixes and the reduce use the redactor of the files
+	redactor, err := run.Redactor()
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	api, err := scopes.NewLLM(run.Options.Model, redactor)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if err := run.Scope.CheckEstimate(run); err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	output := newRunOutput(os.Stdout, run)
+
+	err = run.Scope.RunPromptOnFiles(api, run, output.callback(runCallback(run, api)))
+	run.PrintSummary()
+	if err != nil {
+		// Still output the files that finished so partial results can be parsed
+		if outputErr := output.write(""); outputErr != nil {
+			return ez.Wrap(op, outputErr)
+		}
+		return ez.Wrap(op, err)
+	}
+
+	report := ""
+	if run.Options.ReducePrompt != "" {
+		report, err = run.Scope.ReduceResponses(api, run)
+
+		// Keep the usage of the reduce calls even when one of them failed
+		if saveErr := run.Save(); saveErr != nil {
+			return ez.Wrap(op, saveErr)
+		}
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		if err := reportCallback(run)(report); err != nil {
+			return ez.Wrap(op, err)
+		}
+	}
+
+	return output.write(report)
+}
+
+// runOptionsFromFlags builds the options of a run from the flags of the
+// prompt command
+func runOptionsFromFlags(c *cli.Context) (scopes.RunOptions, error) {
+	const op = "cli.runOptionsFromFlags"
+
+	options := scopes.RunOptions{
+		Prompt:       c.String("prompt"),
+		TemplateFile: c.String("template"),
+		PromptName:   c.String("use"),
+		ContextFiles: c.StringSlice("context"),
+		Model:        modelFromFlags(c),
+		Save:         c.Bool("save") || c.IsSet("out-dir"),
+		OutDir:       c.String("out-dir"),
+		OutName:      c.String("out-name"),
+		ReducePrompt: c.String("reduce-prompt"),
+		KeepGoing:    c.Bool("keep-going"),
+		Diff:         c.Bool("diff") || c.Bool("diff-full"),
+		DiffContext:  scopes.DefaultDiffContext,
+		DiffFull:     c.Bool("diff-full"),
+		Format:       c.String("format"),
+		Concurrency:  c.Int("concurrency"),
+		Extract:      c.StringSlice("extract"),
+		Filters:      c.StringSlice("filter"),
+		NoRedact:     c.Bool("no-redact"),
+		Include:      c.StringSlice("include"),
+		Exclude:      c.StringSlice("exclude"),
+		ChangedSince: c.String("changed-since"),
+	}
+
+	for _, pattern := range append(append([]string{}, options.Include...), options.Exclude...) {
+		if err := files.ValidateGlob(pattern); err != nil {
+			return options, ez.Wrap(op, err)
+		}
+	}
+
+	for _, spec := range options.Extract {
+		if err := extract.Validate(spec); err != nil {
+			return options, ez.Wrap(op, err)
+		}
+	}
+
+	if err := validateFormat(options.Format); err != nil {
+		return options, ez.Wrap(op, err)
+	}
+
+	if c.IsSet("diff-context") {
+		options.DiffContext = c.Int("diff-context")
+	}
+
+	if err := budgetFromFlags(c, &options); err != nil {
+		return options, ez.Wrap(op, err)
+	}
+
+	sources := 0
+	for _, flag := range []string{"prompt", "template", "use"} {
+		if c.String(flag) != "" {
+			sources++
+		}
+	}
+
+	if sources == 0 {
+		return options, ez.New(op, ez.EINVALID, "A prompt is required, use --prompt, --template or --use", nil)
+	} else if sources > 1 {
+		return options, ez.New(op, ez.EINVALID, "Use only one of --prompt, --template or --use", nil)
+	}
+
+	if options.TemplateFile != "" {
+		data, err := os.ReadFile(options.TemplateFile)
+		if err != nil {
+			errMsg := fmt.Sprintf("Failed to read template %s", options.TemplateFile)
+			return options, ez.New(op, ez.ENOTFOUND, errMsg, err)
+		}
+		options.Prompt = string(data)
+	}
+
+	if options.PromptName != "" {
+		p, err := prompts.Load(options.PromptName)
+		if err != nil {
+			return options, ez.Wrap(op, err)
+		}
+
+		// The prompt defaults only apply when the flags were not set
+		options.Prompt = p.Body
+		options.System = p.System
+		if p.Model != "" && !c.IsSet("model") {
+			options.Model = p.Model
+		}
+		if !c.IsSet("save") {
+			options.Save = p.Output == prompts.OutputSave || c.IsSet("out-dir")
+		}
+	}
+
+	if patch := c.String("patch"); patch != "" {
+		if patch != scopes.PatchApply && patch != scopes.PatchStage && patch != scopes.PatchSave {
+			errMsg := fmt.Sprintf("Invalid patch action %s, expected apply, stage or save", patch)
+			return options, ez.New(op, ez.EINVALID, errMsg, nil)
+		}
+
+		options.Mode = scopes.ModePatch
+		options.PatchAction = patch
+		options.PatchRetries = c.Int("patch-retries")
+		options.System = strings.TrimSpace(options.System + "\n\n" + patchSystemPrompt)
+	}
+
+	if options.Format == scopes.FormatSARIF {
+		if options.Mode == scopes.ModePatch || options.ReducePrompt != "" {
+			return options, ez.New(op, ez.EINVALID, "--format sarif can't be used with --patch or --reduce-prompt", nil)
+		}
+
+		options.System = strings.TrimSpace(options.System + "\n\n" + reviewSystemPrompt)
+	}
+
+	if (len(options.Extract) > 0 || len(options.Filters) > 0) && (options.Mode == scopes.ModePatch || options.Format == scopes.FormatSARIF) {
+		return options, ez.New(op, ez.EINVALID, "--extract and --filter can't be used with --patch or --format sarif", nil)
+	}
+
+	return options, nil
+}
+
+// modelFromFlags returns the model of a run, commands that compare models
+// take several and the first one is used by default
+func modelFromFlags(c *cli.Context) string {
+	if models := c.StringSlice("model"); len(models) > 0 {
+		return models[0]
+	}
+	return c.String("model")
+}
+
+// budgetFromFlags sets the limits of a run from the flags, or from the project
+// config when the flags are not set
+func budgetFromFlags(c *cli.Context, options *scopes.RunOptions) error {
+	const op = "cli.budgetFromFlags"
+
+	projectConfig, err := config.Load()
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	options.Budget = projectConfig.Budget
+	if c.IsSet("budget") {
+		options.Budget = c.Float64("budget")
+	}
+
+	options.MaxTokensTotal = projectConfig.MaxTokensTotal
+	if c.IsSet("max-tokens-total") {
+		options.MaxTokensTotal = c.Int("max-tokens-total")
+	}
+
+	return nil
+}
+
+// runCallback returns the callback for the mode of a run
+func runCallback(run *scopes.Run, api llm.API) scopes.LLMCallback {
+	switch run.Options.Mode {
+	case scopes.ModeApply:
+		return serialized(applyCallback())
+	case scopes.ModePatch:
+		return serialized(patchCallback(run, api))
+	case scopes.ModeTests:
+		return serialized(testsCallback(run, api))
+	}
+
+	if run.Options.Format == scopes.FormatSARIF {
+		return reviewCallback(run)
+	}
+
+	return serialized(llmCallback(run))
+}
+
+// serialized runs a callback for one file at a time, for callbacks that prompt,
+// print responses or change the working tree
+func serialized(callback scopes.LLMCallback) scopes.LLMCallback {
+	var mu sync.Mutex
+
+	return func(file *scopes.RunFile) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return callback(file)
+	}
+}
+
+// llmCallback creates a callback function, responses are only printed as they
+// arrive with the text format
+func llmCallback(run *scopes.Run) scopes.LLMCallback {
+	return func(file *scopes.RunFile) error {
 		const op = "llmCallback"
 
-		if save {
-			outputPath := path + ".llm.md"
+		if run.Options.Save {
+			outputPath, err := run.OutputPath(file)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
 
-			// Write response to file
-			if err := os.WriteFile(outputPath, []byte(response), 0644); err != nil {
-				return ez.Wrap(op, fmt.Errorf("failed to write response file: %w", err))
+			if err := run.WriteOutput(outputPath, file.Response); err != nil {
+				return ez.Wrap(op, err)
+			}
+			file.Output = outputPath
+
+			fmt.Fprintf(os.Stderr, "Response written to: %s\n", outputPath)
+		} else if isTextFormat(run.Options.Format) && run.Options.Comparison == "" {
+			fmt.Println(file.Response)
+		}
+
+		return nil
+	}
+}
+
+// reportCallback creates a callback function for the reduced report, other
+// formats than text include it in their output
+func reportCallback(run *scopes.Run) func(string) error {
+	return func(report string) error {
+		const op = "reportCallback"
+
+		if run.Options.Save {
+			outputPath := run.ReportPath()
+			if err := run.WriteOutput(outputPath, report); err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			run.Report = outputPath
+			if err := run.Save(); err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if _, err := run.WriteIndex(); err != nil {
+				return ez.Wrap(op, err)
 			}
 
-			fmt.Println("Response written to: %s\n", outputPath)
-		} else {
-			fmt.Println(response)
+			fmt.Fprintf(os.Stderr, "Report written to: %s\n", outputPath)
+		} else if isTextFormat(run.Options.Format) {
+			fmt.Println(report)
 		}
 
 		return nil
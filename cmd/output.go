@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+// fileRecord is the machine-readable result of a file of a run
+type fileRecord struct {
+	Path       string            `json:"path"`
+	Status     scopes.FileStatus `json:"status"`
+	Model      string            `json:"model,omitempty"`
+	Response   string            `json:"response,omitempty"`
+	Usage      llm.Usage         `json:"usage"`
+	DurationMs int64             `json:"durationMs"`
+	Error      string            `json:"error,omitempty"`
+	Redactions map[string]int    `json:"redactions,omitempty"`
+}
+
+// runRecord is the JSON document of a run
+type runRecord struct {
+	Run    string        `json:"run"`
+	Files  []*fileRecord `json:"files"`
+	Report string        `json:"report,omitempty"`
+}
+
+// validateFormat returns an error if the output format is unknown
+func validateFormat(format string) error {
+	const op = "cli.validateFormat"
+
+	switch format {
+	case "", scopes.FormatText, scopes.FormatJSON, scopes.FormatJSONL, scopes.FormatMarkdown, scopes.FormatSARIF:
+		return nil
+	default:
+		errMsg := fmt.Sprintf("Invalid format %s, expected text, json, jsonl, markdown or sarif", format)
+		return ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+}
+
+// isTextFormat returns true if the responses are printed as they arrive
+func isTextFormat(format string) bool {
+	return format == "" || format == scopes.FormatText
+}
+
+// fileRecords returns the records of the files of a run that were processed
+func fileRecords(run *scopes.Run) []*fileRecord {
+	records := []*fileRecord{}
+	for _, file := range run.Files {
+		if file.Status == scopes.FilePending {
+			continue
+		}
+		records = append(records, newFileRecord(file))
+	}
+	return records
+}
+
+func newFileRecord(file *scopes.RunFile) *fileRecord {
+	return &fileRecord{
+		Path:       file.Path,
+		Status:     file.Status,
+		Model:      file.Model,
+		Response:   file.Response,
+		Usage:      file.Usage,
+		DurationMs: file.DurationMs,
+		Error:      file.Error,
+		Redactions: file.Redactions,
+	}
+}
+
+// runOutput writes the results of a run in its format. The text format is
+// printed by the callbacks and JSONL records are written as each file
+// finishes, every other format is written once the run ends
+type runOutput struct {
+	w   io.Writer
+	run *scopes.Run
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	written map[string]bool // Files whose JSONL record was already written
+}
+
+func newRunOutput(w io.Writer, run *scopes.Run) *runOutput {
+	return &runOutput{w: w, run: run, encoder: json.NewEncoder(w), written: make(map[string]bool)}
+}
+
+// callback wraps the callback of the run so the JSONL record of each file is
+// written as soon as the callback finishes with it
+func (o *runOutput) callback(next scopes.LLMCallback) scopes.LLMCallback {
+	if o.run.Options.Format != scopes.FormatJSONL {
+		return next
+	}
+
+	return func(file *scopes.RunFile) error {
+		const op = "runOutput.callback"
+
+		err := next(file)
+
+		// The status of the file is only updated after the callback returns
+		record := newFileRecord(file)
+		record.Status = scopes.FileDone
+		if err != nil {
+			record.Status = scopes.FileFailed
+			record.Error = ez.ErrorMessage(err)
+		}
+
+		if writeErr := o.writeRecord(record); writeErr != nil {
+			return ez.Wrap(op, writeErr)
+		}
+
+		return err
+	}
+}
+
+func (o *runOutput) writeRecord(record *fileRecord) error {
+	const op = "runOutput.writeRecord"
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.encoder.Encode(record); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing record", err)
+	}
+	o.written[record.Path] = true
+
+	return nil
+}
+
+// write writes the results of the run and its reduced report, for JSONL only
+// the files that failed before reaching the callback and the report are left
+func (o *runOutput) write(report string) error {
+	const op = "runOutput.write"
+
+	w, run := o.w, o.run
+	records := fileRecords(run)
+
+	switch run.Options.Format {
+	case scopes.FormatJSON:
+		data, err := json.MarshalIndent(&runRecord{Run: run.ID, Files: records, Report: report}, "", "  ")
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Error marshaling run", err)
+		}
+		fmt.Fprintln(w, string(data))
+
+	case scopes.FormatJSONL:
+		for _, record := range records {
+			if o.written[record.Path] {
+				continue
+			}
+			if err := o.writeRecord(record); err != nil {
+				return ez.Wrap(op, err)
+			}
+		}
+		if report != "" {
+			if err := o.encoder.Encode(map[string]string{"report": report}); err != nil {
+				return ez.New(op, ez.EINTERNAL, "Error writing report", err)
+			}
+		}
+
+	case scopes.FormatMarkdown:
+		fmt.Fprint(w, markdownReport(run, records, report))
+
+	case scopes.FormatSARIF:
+		log, err := sarifLog(run)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		data, err := json.MarshalIndent(log, "", "  ")
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Error marshaling SARIF log", err)
+		}
+		fmt.Fprintln(w, string(data))
+	}
+
+	return nil
+}
+
+// markdownReport builds a single document with a table of contents and a
+// section per file
+func markdownReport(run *scopes.Run, records []*fileRecord, report string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Run %s\n\n", run.ID)
+	fmt.Fprintf(&b, "Scope `%s`, model `%s`\n\n", run.Scope.Name, run.Options.Model)
+
+	b.WriteString("## Contents\n\n")
+	if report != "" {
+		b.WriteString("- [Report](#report)\n")
+	}
+	for _, record := range records {
+		fmt.Fprintf(&b, "- [%s](#%s)\n", record.Path, markdownAnchor(record.Path))
+	}
+	b.WriteString("\n")
+
+	if report != "" {
+		fmt.Fprintf(&b, "## Report\n\n%s\n\n", strings.TrimSpace(report))
+	}
+
+	for _, record := range records {
+		fmt.Fprintf(&b, "## %s\n\n", record.Path)
+
+		switch record.Status {
+		case scopes.FileFailed:
+			fmt.Fprintf(&b, "**Failed:** %s\n\n", record.Error)
+		case scopes.FileSkipped:
+			b.WriteString("Skipped binary file\n\n")
+		default:
+			fmt.Fprintf(&b, "%s\n\n", strings.TrimSpace(record.Response))
+		}
+	}
+
+	return b.String()
+}
+
+// markdownAnchor returns the anchor generated for a heading, lowercase with
+// punctuation removed and spaces replaced by dashes
+func markdownAnchor(heading string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(heading) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+const patchSystemPrompt = "You modify source files by writing patches. Answer only with a unified diff " +
+	"in git format (with --- a/<path> and +++ b/<path> headers, paths relative to the repository root) " +
+	"inside a single ```diff code block, without explanations."
+
+// DefaultPatchRetries is the amount of times a patch that fails to apply is
+// sent back to the LLM to be fixed
+const DefaultPatchRetries = 2
+
+// patchCallback creates a callback that validates the patch returned for each
+// file with git apply --check and then applies, stages or saves it. Patches
+// that fail to apply or change other files are fed back to the LLM
+func patchCallback(run *scopes.Run, api llm.API) scopes.LLMCallback {
+	return func(file *scopes.RunFile) error {
+		const op = "patchCallback"
+
+		checkArgs := []string{"--check"}
+		if run.Options.PatchAction == scopes.PatchStage {
+			checkArgs = append(checkArgs, "--cached")
+		}
+
+		patch := extractPatch(file.Response)
+		messages := append([]llm.Message{}, file.Request.Messages...)
+
+		for attempt := 0; ; attempt++ {
+			if err := checkTruncated(file); err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			patchPath, err := writeTempFile(file.Path+".patch", patch)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			err = checkPatch(file.Path, patch)
+			if err == nil {
+				err = git.ApplyPatch(patchPath, checkArgs...)
+			}
+			if err == nil {
+				err = finishPatch(run, file.Path, patchPath, patch)
+				os.Remove(patchPath)
+				return err
+			}
+			os.Remove(patchPath)
+
+			if attempt >= run.Options.PatchRetries {
+				errMsg := fmt.Sprintf("Patch for %s doesn't apply after %d retries: %s", file.Path, attempt, ez.ErrorMessage(err))
+				return ez.Root(op, ez.EINVALID, errMsg)
+			}
+
+			fmt.Fprintf(os.Stderr, "Patch for %s doesn't apply, retrying (%d/%d)... ", file.Path, attempt+1, run.Options.PatchRetries)
+
+			// Send the error back so the LLM can fix its patch
+			messages = append(messages,
+				llm.Message{Role: llm.RoleAssistant, Content: file.Response},
+				llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf(
+					"The patch was rejected with this error:\n%s\n\nAnswer with a corrected unified diff.",
+					ez.ErrorMessage(err))},
+			)
+
+			// Keep the system prompt and the context files of the first request
+			retry := *file.Request
+			retry.Messages = messages
+
+			response, err := api.Send(&retry)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed", err)
+				return ez.Wrap(op, err)
+			}
+			fmt.Fprintln(os.Stderr, "Ok")
+
+			file.Usage.Add(response.Usage)
+			file.Response = response.Content
+			file.Truncated = response.Truncated
+			patch = extractPatch(response.Content)
+		}
+	}
+}
+
+// checkPatch returns an error if a patch changes any file besides the one it
+// was written for, or a file the project policy blocks
+func checkPatch(path, patch string) error {
+	const op = "cli.checkPatch"
+
+	paths, err := policy.DiffPaths(patch)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if len(paths) == 0 {
+		return ez.Root(op, ez.EINVALID, "The patch doesn't change "+path)
+	}
+
+	for _, changed := range paths {
+		if filepath.Clean(changed) != filepath.Clean(path) {
+			errMsg := fmt.Sprintf("The patch changes %s, only %s can be changed", changed, path)
+			return ez.Root(op, ez.EINVALID, errMsg)
+		}
+	}
+
+	projectPolicy, err := policy.Load()
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if err := projectPolicy.CheckDiff(patch); err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// finishPatch applies, stages or saves a patch that passed the check
+func finishPatch(run *scopes.Run, path, patchPath, patch string) error {
+	const op = "cli.finishPatch"
+
+	switch run.Options.PatchAction {
+	case scopes.PatchApply:
+		if err := git.ApplyPatch(patchPath); err != nil {
+			return ez.Wrap(op, err)
+		}
+		fmt.Fprintf(os.Stderr, "Applied patch to %s\n", path)
+
+	case scopes.PatchStage:
+		if err := git.ApplyPatch(patchPath, "--cached"); err != nil {
+			return ez.Wrap(op, err)
+		}
+		fmt.Fprintf(os.Stderr, "Staged patch for %s\n", path)
+
+	default:
+		outputPath := filepath.Join(files.GetPatchDirPath(run.ID), path+".patch")
+		if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+			return ez.New(op, ez.EINTERNAL, "Error creating patches directory", err)
+		}
+
+		if err := os.WriteFile(outputPath, []byte(patch), 0644); err != nil {
+			return ez.New(op, ez.EINTERNAL, "Failed to write patch file", err)
+		}
+		fmt.Fprintf(os.Stderr, "Patch written to: %s\n", outputPath)
+	}
+
+	return nil
+}
+
+// extractPatch returns the unified diff of a response
+func extractPatch(response string) string {
+	blocks := extract.CodeBlocks(response)
+
+	patch := strings.TrimSpace(response)
+	for i, block := range blocks {
+		if block.Language == "diff" || block.Language == "patch" || i == 0 {
+			patch = block.Code
+		}
+		if block.Language == "diff" || block.Language == "patch" {
+			break
+		}
+	}
+
+	return strings.TrimRight(patch, "\n") + "\n"
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import "testing"
+
+func TestCheckPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		patch   string
+		wantErr bool
+	}{
+		{
+			name:  "changes the file",
+			path:  "pkg/a.go",
+			patch: "--- a/pkg/a.go\n+++ b/pkg/a.go\n@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name:  "git header",
+			path:  "./pkg/a.go",
+			patch: "diff --git a/pkg/a.go b/pkg/a.go\n--- a/pkg/a.go\n+++ b/pkg/a.go\n@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name:    "changes another file",
+			path:    "pkg/a.go",
+			patch:   "--- a/pkg/a.go\n+++ b/pkg/a.go\n@@ -1 +1 @@\n-a\n+b\n--- a/.env\n+++ b/.env\n@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name:    "renames the file",
+			path:    "pkg/a.go",
+			patch:   "diff --git a/pkg/a.go b/pkg/b.go\nrename from pkg/a.go\nrename to pkg/b.go\n",
+			wantErr: true,
+		},
+		{
+			name:    "no file header",
+			path:    "pkg/a.go",
+			patch:   "@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			path:    "pkg/a.go",
+			patch:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPatch(tt.path, tt.patch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+// MaxPRDiffChars is the maximum size of the diffs sent in a single call, larger
+// branches are summarized per file first
+const MaxPRDiffChars = 100000
+
+// prTemplatePaths are the locations where GitHub looks for a pull request template
+var prTemplatePaths = []string{
+	".github/pull_request_template.md",
+	".github/PULL_REQUEST_TEMPLATE.md",
+	"pull_request_template.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"docs/pull_request_template.md",
+	"docs/PULL_REQUEST_TEMPLATE.md",
+}
+
+const prSystemPrompt = "You write pull request descriptions for code reviewers. Answer only with the body of the " +
+	"pull request in markdown, without a title, code fences around it or explanations. Be concise and concrete."
+
+const prDefaultSections = "Use these sections:\n" +
+	"## Summary\nWhat the branch does and why, in 1-3 sentences.\n" +
+	"## Changes\nA bullet list of the notable changes.\n" +
+	"## Risk\nWhat could break and what reviewers should look at closely.\n" +
+	"## Testing notes\nHow the changes can be verified."
+
+const prFileSummaryPrompt = "Summarize the changes of this diff for a pull request description in a few bullet " +
+	"points. Mention behavior changes, new or removed APIs and anything risky."
+
+func PRDescriptionCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "pr-description",
+		Usage: "Generate the description of a pull request for the current branch",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "base",
+				Usage: "The branch the pull request is merged into",
+				Value: "main",
+			},
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Usage:   "Write the description to this file instead of printing it",
+				Aliases: []string{"o"},
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the diff to the provider instead of redacting them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.PRDescriptionCmd"
+
+			mergeBase, err := git.GetMergeBase(c.String("base"), "HEAD")
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			commits, err := git.GetCommitLog(mergeBase, "HEAD")
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if len(commits) == 0 {
+				return ez.New(op, ez.EINVALID, fmt.Sprintf("HEAD has no commits that are not in %s", c.String("base")), nil)
+			}
+
+			paths, err := git.GetChangedFiles(mergeBase, "HEAD")
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			projectPolicy, err := policy.Load()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			redactor, err := commandRedactor(c)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			api, err := scopes.NewLLM(c.String("model"), redactor)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			description, err := generatePRDescription(api, projectPolicy, mergeBase, commits, paths)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if c.String("output") == "" {
+				fmt.Println(description)
+				return nil
+			}
+
+			if err := os.WriteFile(c.String("output"), []byte(description+"\n"), 0644); err != nil {
+				return ez.New(op, ez.EINTERNAL, "Failed to write file: "+c.String("output"), err)
+			}
+			fmt.Fprintf(os.Stderr, "Description written to: %s\n", c.String("output"))
+
+			return nil
+		},
+	}
+}
+
+// generatePRDescription builds the description from the commits, diffstat and
+// diffs of the changed files between the merge base and HEAD
+func generatePRDescription(api llm.API, projectPolicy *policy.Policy, mergeBase string, commits, paths []string) (string, error) {
+	const op = "cli.generatePRDescription"
+
+	stat, err := git.GetDiffStat(mergeBase, "HEAD")
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	changes, err := prFileChanges(api, projectPolicy, mergeBase, paths)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("Write the description of a pull request for the following branch.\n\n")
+
+	if template := readPRTemplate(); template != "" {
+		fmt.Fprintf(&prompt, "Follow the headings of the pull request template of the repository and fill in "+
+			"every section:\n\n%s\n\n", template)
+	} else {
+		fmt.Fprintf(&prompt, "%s\n\n", prDefaultSections)
+	}
+
+	fmt.Fprintf(&prompt, "Commits:\n%s\n\n", strings.Join(commits, "\n"))
+	fmt.Fprintf(&prompt, "Diffstat:\n%s", stat)
+	prompt.WriteString(changes)
+
+	fmt.Fprint(os.Stderr, "Writing description... ")
+	response, err := api.Send(llm.NewRequest(prSystemPrompt, "", prompt.String()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed", err)
+		return "", ez.Wrap(op, err)
+	}
+	fmt.Fprintln(os.Stderr, "Ok")
+
+	return strings.TrimSpace(response.Content), nil
+}
+
+// prFileChanges returns the diffs of the changed files, or a summary of each of
+// them when the diffs don't fit in a single call
+func prFileChanges(api llm.API, projectPolicy *policy.Policy, mergeBase string, paths []string) (string, error) {
+	const op = "cli.prFileChanges"
+
+	diffs := make([]string, 0, len(paths))
+	size := 0
+	for _, path := range paths {
+		diff, err := git.GetFileDiff(mergeBase, "HEAD", path, scopes.DefaultDiffContext)
+		if err != nil {
+			return "", ez.Wrap(op, err)
+		}
+
+		// Checked before any call so a blocked file never reaches the provider
+		if err := projectPolicy.CheckDiff(diff); err != nil {
+			return "", ez.Wrap(op, err)
+		}
+		diffs = append(diffs, diff)
+		size += len(diff)
+	}
+
+	var b strings.Builder
+
+	if size <= MaxPRDiffChars {
+		b.WriteString("\nDiffs:\n")
+		for _, diff := range diffs {
+			b.WriteString(diff)
+		}
+		return b.String(), nil
+	}
+
+	b.WriteString("\nSummaries of the changes of each file:\n")
+	for i, path := range paths {
+		diff := scopes.Truncate(diffs[i], MaxPRDiffChars)
+
+		fmt.Fprintf(os.Stderr, "Summarizing %s... ", path)
+		response, err := api.Send(llm.NewRequest("", "", fmt.Sprintf("%s\n\nFile: %s\n%s", prFileSummaryPrompt, path, diff)))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed", err)
+			return "", ez.Wrap(op, err)
+		}
+		fmt.Fprintln(os.Stderr, "Ok")
+
+		fmt.Fprintf(&b, "\nFile: %s\n%s\n", path, strings.TrimSpace(response.Content))
+	}
+
+	return b.String(), nil
+}
+
+// readPRTemplate returns the pull request template of the repository, if any
+func readPRTemplate() string {
+	for _, path := range prTemplatePaths {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/prompts"
+	"github.com/vanclief/ez"
+)
+
+func PromptCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "prompt",
+		Usage: "Manage the prompt library",
+		Subcommands: []*cli.Command{
+			promptListCmd(),
+			promptShowCmd(),
+			promptNewCmd(),
+			promptEditCmd(),
+		},
+	}
+}
+
+func promptListCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List the prompts of the project and the user",
+		Action: func(c *cli.Context) error {
+			const op = "cli.promptListCmd"
+
+			library, err := prompts.List()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			if len(library) == 0 {
+				fmt.Printf("No prompts found, create one with: coderunner prompt new <name>\n")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSOURCE\tMODEL\tDESCRIPTION")
+			for _, p := range library {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, p.Source, p.Model, p.Description)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func promptShowCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "Show a prompt",
+		ArgsUsage: "<name>",
+		Action: func(c *cli.Context) error {
+			const op = "cli.promptShowCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the name of the prompt", nil)
+			}
+
+			p, err := prompts.Load(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			fmt.Printf("Prompt: %s (%s)\n", p.Name, p.Path)
+			if p.Description != "" {
+				fmt.Printf("Description: %s\n", p.Description)
+			}
+			if p.Model != "" {
+				fmt.Printf("Model: %s\n", p.Model)
+			}
+			if p.System != "" {
+				fmt.Printf("System: %s\n", p.System)
+			}
+			fmt.Printf("Output: %s\n\n%s\n", p.Output, p.Body)
+
+			return nil
+		},
+	}
+}
+
+func promptNewCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "new",
+		Usage:     "Create a new prompt and open it in the editor",
+		ArgsUsage: "<name>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "user",
+				Usage: "Create the prompt in the user directory instead of the project",
+			},
+			&cli.StringFlag{
+				Name:    "editor",
+				Usage:   "Editor to open the file",
+				Aliases: []string{"e"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.promptNewCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the name of the prompt", nil)
+			}
+
+			path, err := prompts.Create(c.Args().First(), c.Bool("user"))
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			fmt.Printf("Created prompt file %s\n", path)
+
+			err = files.OpenFile(path, c.String("editor"))
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			return nil
+		},
+	}
+}
+
+func promptEditCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "edit",
+		Usage:     "Edit a prompt",
+		ArgsUsage: "<name>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "editor",
+				Usage:   "Editor to open the file",
+				Aliases: []string{"e"},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.promptEditCmd"
+
+			if c.Args().Len() != 1 {
+				return ez.New(op, ez.EINVALID, "Expected the name of the prompt", nil)
+			}
+
+			path, _, err := prompts.Find(c.Args().First())
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			err = files.OpenFile(path, c.String("editor"))
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/sarif"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+const reviewSystemPrompt = "You review source files. Answer only with a JSON array of findings inside a single ```json " +
+	"code block, or [] when there are no findings. Each finding is an object with: ruleId (a short kebab-case " +
+	"identifier of the kind of problem), severity (error, warning or note), message (the problem and how to fix it), " +
+	"file (the path of the reviewed file), startLine and endLine (1-based line numbers in the reviewed file) and " +
+	"snippet (the code of those lines the finding is about, quoted exactly)."
+
+// reviewCallback creates a callback that parses the findings of each file and
+// keeps the ones whose lines exist, the response is replaced by the checked
+// findings so the SARIF log can be built from the run
+func reviewCallback(run *scopes.Run) scopes.LLMCallback {
+	return func(file *scopes.RunFile) error {
+		const op = "reviewCallback"
+
+		findings, err := sarif.ParseFindings(file.Response)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		// The lines are checked against the content that was reviewed
+		content, err := run.ReadContent(file)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		findings, dropped := sarif.CheckLines(file.Path, string(content), findings)
+		for _, reason := range dropped {
+			fmt.Fprintf(os.Stderr, "Dropped finding of %s: %s\n", file.Path, reason)
+		}
+
+		data, err := json.Marshal(findings)
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Error marshaling findings", err)
+		}
+		file.Response = string(data)
+
+		return nil
+	}
+}
+
+// sarifLog builds a SARIF log with a single run holding the findings of every
+// reviewed file, failed files are reported as tool notifications
+func sarifLog(run *scopes.Run) (*sarif.Log, error) {
+	const op = "cli.sarifLog"
+
+	repositoryURI, err := git.GetRepositoryURI()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	sarifRun := sarif.NewRun("coderunner", repositoryURI, run.Scope.TargetCommit)
+
+	for _, file := range run.Files {
+		switch file.Status {
+		case scopes.FileFailed:
+			sarifRun.AddFailure(file.Path, file.Error)
+
+		case scopes.FileDone:
+			findings := []*sarif.Finding{}
+			if err := json.Unmarshal([]byte(file.Response), &findings); err != nil {
+				sarifRun.AddFailure(file.Path, "Invalid findings in the run checkpoint")
+				continue
+			}
+
+			for _, finding := range findings {
+				sarifRun.AddFinding(finding)
+			}
+		}
+	}
+
+	log := sarif.NewLog()
+	log.Runs = append(log.Runs, sarifRun)
+
+	return log, nil
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vanclief/coderunner/gofile"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+// DefaultFixRetries is the amount of times failing tests are sent back to the
+// LLM to be fixed
+const DefaultFixRetries = 2
+
+const testsSystemPrompt = "You write Go tests. Answer only with a complete Go test file in a single fenced code " +
+	"block, without explanations. Write table-driven tests with the standard testing package, in the same " +
+	"package as the tested file, and don't use external test dependencies."
+
+const testsPrompt = "Write table-driven tests for the exported functions of {{.Path}}.\n\n" +
+	"```go\n{{.Content}}\n```"
+
+func testsCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "tests",
+		Usage: "Write table-driven tests for each Go file of a scope and fix them until they pass",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "prompt",
+				Usage:   "Additional instructions for the tests",
+				Aliases: []string{"p"},
+			},
+			&cli.StringSliceFlag{
+				Name:  "context",
+				Usage: "Files sent as context before each file, in addition to the contextFiles of the scope",
+			},
+			&cli.StringFlag{
+				Name:    "model",
+				Usage:   "The model to use (o1, o1-mini, 4o, sonnet)",
+				Aliases: []string{"m"},
+				Value:   "sonnet",
+			},
+			&cli.IntFlag{
+				Name:  "fix-retries",
+				Usage: "Times the go vet and go test errors are sent back to the LLM to fix the tests",
+				Value: DefaultFixRetries,
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send secrets found in the files to the provider instead of redacting them",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: "Continue with the next file when a file fails instead of aborting the run",
+			},
+			&cli.Float64Flag{
+				Name:  "budget",
+				Usage: "Maximum spend in USD of the run, defaults to the budget of coderunner/config.json",
+			},
+			&cli.IntFlag{
+				Name:  "max-tokens-total",
+				Usage: "Maximum tokens used by the run, defaults to the maxTokensTotal of coderunner/config.json",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			const op = "cli.testsCmd"
+
+			selectedScope, err := scopes.LoadSelectedScope()
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			options := scopes.RunOptions{
+				Prompt:       testsPrompt,
+				System:       testsSystemPrompt,
+				ContextFiles: c.StringSlice("context"),
+				Model:        c.String("model"),
+				KeepGoing:    c.Bool("keep-going"),
+				Mode:         scopes.ModeTests,
+				DiffContext:  scopes.DefaultDiffContext,
+				FixRetries:   c.Int("fix-retries"),
+				NoRedact:     c.Bool("no-redact"),
+			}
+
+			if extra := strings.TrimSpace(c.String("prompt")); extra != "" {
+				options.Prompt += "\n\n" + extra
+			}
+
+			if err := budgetFromFlags(c, &options); err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			run, err := scopes.NewRun(selectedScope, options)
+			if err != nil {
+				return ez.Wrap(op, err)
+			}
+
+			// Only Go source files get tests
+			for _, file := range run.Files {
+				if filepath.Ext(file.Path) != ".go" || strings.HasSuffix(file.Path, "_test.go") {
+					file.Status = scopes.FileSkipped
+				}
+			}
+
+			return executeRun(run)
+		},
+	}
+}
+
+// testsCallback creates a callback that writes or merges the tests of each
+// file and runs go vet and go test on its package. Failures are fed back to the
+// LLM, and the test file is restored if the tests never pass
+func testsCallback(run *scopes.Run, api llm.API) scopes.LLMCallback {
+	return func(file *scopes.RunFile) error {
+		const op = "testsCallback"
+
+		testPath := strings.TrimSuffix(file.Path, ".go") + "_test.go"
+
+		original, err := os.ReadFile(testPath)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return ez.New(op, ez.EINTERNAL, "Failed to read file: "+testPath, err)
+		}
+
+		messages := append([]llm.Message{}, file.Request.Messages...)
+
+		for attempt := 0; ; attempt++ {
+			if err := checkTruncated(file); err != nil {
+				if restoreErr := restoreTestFile(testPath, original, exists); restoreErr != nil {
+					return ez.Wrap(op, restoreErr)
+				}
+				return ez.Wrap(op, err)
+			}
+
+			err := writeTests(testPath, file.Response, string(original), exists)
+			if err == nil {
+				err = checkPackage(filepath.Dir(file.Path))
+			}
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "Tests written to: %s\n", testPath)
+				return nil
+			}
+
+			if attempt >= run.Options.FixRetries {
+				if restoreErr := restoreTestFile(testPath, original, exists); restoreErr != nil {
+					return ez.Wrap(op, restoreErr)
+				}
+
+				errMsg := fmt.Sprintf("Tests for %s fail after %d fix attempts: %s", file.Path, attempt, ez.ErrorMessage(err))
+				return ez.Root(op, ez.EINVALID, errMsg)
+			}
+
+			fmt.Fprintf(os.Stderr, "Tests for %s fail, retrying (%d/%d)... ", file.Path, attempt+1, run.Options.FixRetries)
+
+			// Send the errors back so the LLM can fix its tests
+			messages = append(messages,
+				llm.Message{Role: llm.RoleAssistant, Content: file.Response},
+				llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf(
+					"The tests failed with these errors:\n%s\n\nAnswer with the complete corrected test file.",
+					ez.ErrorMessage(err))},
+			)
+
+			retry := *file.Request
+			retry.Messages = messages
+
+			response, err := api.Send(&retry)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed", err)
+				return ez.Wrap(op, err)
+			}
+			fmt.Fprintln(os.Stderr, "Ok")
+
+			file.Usage.Add(response.Usage)
+			file.Response = response.Content
+			file.Truncated = response.Truncated
+		}
+	}
+}
+
+// writeTests writes the tests of a response, merging them into the existing
+// test file without replacing any of its declarations
+func writeTests(testPath, response, original string, exists bool) error {
+	const op = "cli.writeTests"
+
+	content, err := extractRewrittenFile(response)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if exists {
+		merged, skipped, err := gofile.Merge(original, content)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		if len(skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "Kept the existing %s in %s\n", strings.Join(skipped, ", "), testPath)
+		}
+		content = merged
+	}
+
+	if err := os.WriteFile(testPath, []byte(content), 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to write file: "+testPath, err)
+	}
+
+	return nil
+}
+
+// restoreTestFile puts back the test file as it was before the run
+func restoreTestFile(testPath string, original []byte, exists bool) error {
+	const op = "cli.restoreTestFile"
+
+	var err error
+	if exists {
+		err = os.WriteFile(testPath, original, 0644)
+	} else {
+		err = os.Remove(testPath)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return ez.New(op, ez.EINTERNAL, "Failed to restore file: "+testPath, err)
+	}
+
+	return nil
+}
+
+// checkPackage runs go vet and go test on the package of a directory, the
+// output of the failing command is the error message
+func checkPackage(dir string) error {
+	const op = "cli.checkPackage"
+
+	pkg := "./" + filepath.ToSlash(dir)
+
+	for _, args := range [][]string{{"vet", pkg}, {"test", pkg}} {
+		output, err := exec.Command("go", args...).CombinedOutput()
+		if err != nil {
+			message := strings.TrimSpace(string(output))
+			if message == "" {
+				message = fmt.Sprintf("go %s failed", args[0])
+			}
+			return ez.New(op, ez.EINVALID, message, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/ez"
+)
+
+// Config holds the project settings, it is tracked with the project in
+// coderunner/config.json
+type Config struct {
+	Budget         float64  `json:"budget,omitempty"`         // Default spend limit in USD of a run
+	MaxTokensTotal int      `json:"maxTokensTotal,omitempty"` // Default token limit of a run
+	RedactPatterns []string `json:"redactPatterns,omitempty"` // Regular expressions of project secrets, redacted with the built-in ones
+}
+
+// Path returns the path of the project config file
+func Path() string {
+	return filepath.Join(files.PROJECT_DIR, files.CONFIG_FILE)
+}
+
+// Load reads the project config, a missing file is an empty config
+func Load() (*Config, error) {
+	const op = "config.Load"
+
+	config := &Config{}
+
+	data, err := os.ReadFile(Path())
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading config file", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse config file "+Path(), err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,197 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+const judgePrompt = `You are grading the response of a model against a rubric.
+
+Rubric:
+%s
+
+Response:
+%s
+
+Answer with PASS or FAIL on the first line, followed by a one sentence reason.`
+
+// Name describes the assertion, it identifies it in the baseline
+func (a *Assertion) Name() string {
+	switch a.Type {
+	case AssertJSONPath:
+		if len(a.Equals) > 0 {
+			return fmt.Sprintf("json-path %s == %s", a.Path, string(a.Equals))
+		}
+		return fmt.Sprintf("json-path %s exists", a.Path)
+	case AssertJudge:
+		return "judge " + strconv.Quote(a.Rubric)
+	default:
+		return fmt.Sprintf("%s %s", a.Type, strconv.Quote(a.Value))
+	}
+}
+
+func (a *Assertion) validate() error {
+	const op = "Assertion.validate"
+
+	switch a.Type {
+	case AssertContains, AssertNotContains:
+		if a.Value == "" {
+			return ez.New(op, ez.EINVALID, a.Type+" assertions need a value", nil)
+		}
+	case AssertRegex:
+		if _, err := regexp.Compile(a.Value); err != nil {
+			errMsg := fmt.Sprintf("Invalid regex %s", a.Value)
+			return ez.New(op, ez.EINVALID, errMsg, err)
+		}
+	case AssertJSONPath:
+		if _, err := parsePath(a.Path); err != nil {
+			return ez.Wrap(op, err)
+		}
+		if len(a.Equals) > 0 && !json.Valid(a.Equals) {
+			return ez.New(op, ez.EINVALID, "Invalid equals of json-path "+a.Path, nil)
+		}
+	case AssertJudge:
+		if a.Rubric == "" {
+			return ez.New(op, ez.EINVALID, "judge assertions need a rubric", nil)
+		}
+	default:
+		errMsg := fmt.Sprintf("Invalid assertion type %s, expected contains, not-contains, regex, json-path or judge", a.Type)
+		return ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+
+	return nil
+}
+
+// check returns whether the response satisfies the assertion and the reason it
+// doesn't, judge is only called for judge assertions
+func (a *Assertion) check(response string, judge llm.API) (bool, string) {
+	switch a.Type {
+	case AssertContains:
+		return strings.Contains(response, a.Value), "the response doesn't contain it"
+
+	case AssertNotContains:
+		return !strings.Contains(response, a.Value), "the response contains it"
+
+	case AssertRegex:
+		return regexp.MustCompile(a.Value).MatchString(response), "the response doesn't match it"
+
+	case AssertJSONPath:
+		value, err := lookupJSONPath(response, a.Path)
+		if err != nil {
+			return false, ez.ErrorMessage(err)
+		}
+		if len(a.Equals) == 0 {
+			return true, ""
+		}
+
+		var expected interface{}
+		if err := json.Unmarshal(a.Equals, &expected); err != nil {
+			return false, "invalid equals: " + err.Error()
+		}
+		if !reflect.DeepEqual(value, expected) {
+			actual, _ := json.Marshal(value)
+			return false, "got " + string(actual)
+		}
+		return true, ""
+
+	case AssertJudge:
+		verdict, err := judge.Send(llm.NewRequest("", "", fmt.Sprintf(judgePrompt, a.Rubric, response)))
+		if err != nil {
+			return false, "judge failed: " + ez.ErrorMessage(err)
+		}
+
+		answer := strings.TrimSpace(verdict.Content)
+		line, reason, _ := strings.Cut(answer, "\n")
+		return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "PASS"), strings.TrimSpace(reason)
+	}
+
+	return false, "unknown assertion"
+}
+
+// pathStep is a key of an object or an index of an array
+type pathStep struct {
+	key   string
+	index int
+}
+
+var pathPart = regexp.MustCompile(`^([^\[\]]*)((?:\[\d+\])*)$`)
+
+// parsePath parses a path like $.findings[0].ruleId, the leading $ is optional
+func parsePath(path string) ([]pathStep, error) {
+	const op = "eval.parsePath"
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, ez.New(op, ez.EINVALID, "json-path assertions need a path", nil)
+	}
+
+	steps := make([]pathStep, 0)
+	for _, part := range strings.Split(path, ".") {
+		match := pathPart.FindStringSubmatch(part)
+		if match == nil || match[1] == "" && match[2] == "" {
+			return nil, ez.New(op, ez.EINVALID, "Invalid json-path "+path, nil)
+		}
+
+		if match[1] != "" {
+			steps = append(steps, pathStep{key: match[1], index: -1})
+		}
+		for _, index := range regexp.MustCompile(`\d+`).FindAllString(match[2], -1) {
+			i, _ := strconv.Atoi(index)
+			steps = append(steps, pathStep{index: i})
+		}
+	}
+
+	return steps, nil
+}
+
+// lookupJSONPath returns the value at a path of the JSON of a response, read
+// from a json code block or the whole response
+func lookupJSONPath(response, path string) (interface{}, error) {
+	const op = "eval.lookupJSONPath"
+
+	text := strings.TrimSpace(response)
+	for _, block := range extract.CodeBlocks(response) {
+		if strings.EqualFold(block.Language, "json") {
+			text = block.Code
+			break
+		}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The response is not JSON", err)
+	}
+
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	for _, step := range steps {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			child, ok := node[step.key]
+			if step.index >= 0 || !ok {
+				return nil, ez.Root(op, ez.ENOTFOUND, path+" doesn't exist")
+			}
+			value = child
+		case []interface{}:
+			if step.index < 0 || step.index >= len(node) {
+				return nil, ez.Root(op, ez.ENOTFOUND, path+" doesn't exist")
+			}
+			value = node[step.index]
+		default:
+			return nil, ez.Root(op, ez.ENOTFOUND, path+" doesn't exist")
+		}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,141 @@
+package eval
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []pathStep
+		wantErr bool
+	}{
+		{path: "ruleId", want: []pathStep{{key: "ruleId", index: -1}}},
+		{path: "$.ruleId", want: []pathStep{{key: "ruleId", index: -1}}},
+		{
+			path: "$.findings[0].ruleId",
+			want: []pathStep{{key: "findings", index: -1}, {index: 0}, {key: "ruleId", index: -1}},
+		},
+		{path: "[1][2]", want: []pathStep{{index: 1}, {index: 2}}},
+		{path: "matrix[10][3]", want: []pathStep{{key: "matrix", index: -1}, {index: 10}, {index: 3}}},
+		{path: "", wantErr: true},
+		{path: "$", wantErr: true},
+		{path: "a..b", wantErr: true},
+		{path: "a[x]", wantErr: true},
+		{path: "a[0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parsePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	response := "Here are the findings:\n```JSON\n{\"findings\": [{\"ruleId\": \"sql-injection\", \"line\": 12}], \"ok\": false}\n```\n"
+
+	tests := []struct {
+		name     string
+		response string
+		path     string
+		want     interface{}
+		wantErr  bool
+	}{
+		{name: "string in array", response: response, path: "findings[0].ruleId", want: "sql-injection"},
+		{name: "number", response: response, path: "$.findings[0].line", want: float64(12)},
+		{name: "bool", response: response, path: "ok", want: false},
+		{name: "whole response", response: `[1, 2, 3]`, path: "[2]", want: float64(3)},
+		{name: "missing key", response: response, path: "findings[0].severity", wantErr: true},
+		{name: "index out of range", response: response, path: "findings[1]", wantErr: true},
+		{name: "index on an object", response: response, path: "ok[0]", wantErr: true},
+		{name: "key on an array", response: response, path: "findings.ruleId", wantErr: true},
+		{name: "not json", response: "No findings", path: "findings", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupJSONPath(tt.response, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupJSONPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookupJSONPath(%q) = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	response := "```json\n{\"findings\": [{\"ruleId\": \"sql-injection\"}]}\n```"
+
+	tests := []struct {
+		name      string
+		assertion Assertion
+		want      bool
+	}{
+		{name: "contains", assertion: Assertion{Type: AssertContains, Value: "sql-injection"}, want: true},
+		{name: "does not contain", assertion: Assertion{Type: AssertContains, Value: "xss"}, want: false},
+		{name: "not contains", assertion: Assertion{Type: AssertNotContains, Value: "xss"}, want: true},
+		{name: "regex", assertion: Assertion{Type: AssertRegex, Value: `sql-\w+`}, want: true},
+		{name: "path exists", assertion: Assertion{Type: AssertJSONPath, Path: "findings[0]"}, want: true},
+		{
+			name:      "path equals",
+			assertion: Assertion{Type: AssertJSONPath, Path: "findings[0].ruleId", Equals: json.RawMessage(`"sql-injection"`)},
+			want:      true,
+		},
+		{
+			name:      "path differs",
+			assertion: Assertion{Type: AssertJSONPath, Path: "findings[0].ruleId", Equals: json.RawMessage(`"xss"`)},
+			want:      false,
+		},
+		{
+			name:      "invalid equals",
+			assertion: Assertion{Type: AssertJSONPath, Path: "findings[0].ruleId", Equals: json.RawMessage(`{`)},
+			want:      false,
+		},
+		{name: "missing path", assertion: Assertion{Type: AssertJSONPath, Path: "findings[3]"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := tt.assertion.check(response, nil); got != tt.want {
+				t.Errorf("check() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		assertion Assertion
+		wantErr   bool
+	}{
+		{name: "contains", assertion: Assertion{Type: AssertContains, Value: "a"}},
+		{name: "contains without value", assertion: Assertion{Type: AssertContains}, wantErr: true},
+		{name: "invalid regex", assertion: Assertion{Type: AssertRegex, Value: "("}, wantErr: true},
+		{name: "json-path", assertion: Assertion{Type: AssertJSONPath, Path: "a[0]", Equals: json.RawMessage(`1`)}},
+		{name: "json-path without path", assertion: Assertion{Type: AssertJSONPath}, wantErr: true},
+		{name: "invalid equals", assertion: Assertion{Type: AssertJSONPath, Path: "a", Equals: json.RawMessage(`{`)}, wantErr: true},
+		{name: "judge without rubric", assertion: Assertion{Type: AssertJudge}, wantErr: true},
+		{name: "unknown type", assertion: Assertion{Type: "equals"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.assertion.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
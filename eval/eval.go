@@ -0,0 +1,203 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+// Result holds the outcome of every assertion of a suite, it is also the
+// format of the baseline
+type Result struct {
+	Suite  string        `json:"suite"`
+	Model  string        `json:"model"`
+	RunID  string        `json:"run"`
+	Commit string        `json:"commit"`
+	Files  []*FileResult `json:"files"`
+}
+
+// FileResult holds the assertions of a file
+type FileResult struct {
+	Path       string             `json:"path"`
+	Error      string             `json:"error,omitempty"`
+	Assertions []*AssertionResult `json:"assertions"`
+}
+
+// AssertionResult is the outcome of a single assertion
+type AssertionResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// Diff holds the assertions whose outcome changed against the baseline
+type Diff struct {
+	Regressions  []string // Passed in the baseline and fail now, or are missing from the run
+	Improvements []string // Failed in the baseline and pass now
+	Added        []string // Not part of the baseline
+}
+
+// Run runs the prompt of the suite on its files and checks the assertions of
+// each response, judge answers the judge assertions
+func Run(suite *Suite, options scopes.RunOptions, api, judge llm.API) (*Result, error) {
+	const op = "eval.Run"
+
+	scope, err := suite.LoadScope()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	run, err := scopes.NewRun(scope, options)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	// Responses are only checked once every file is done
+	noop := func(file *scopes.RunFile) error { return nil }
+	if err := scope.RunPromptOnFiles(api, run, noop); err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	result := &Result{
+		Suite:  suite.Name,
+		Model:  options.Model,
+		RunID:  run.ID,
+		Commit: run.Commit,
+		Files:  make([]*FileResult, 0, len(run.Files)),
+	}
+
+	for _, file := range run.Files {
+		if file.Status == scopes.FileSkipped {
+			continue
+		}
+
+		fileResult := &FileResult{Path: file.Path, Error: file.Error, Assertions: []*AssertionResult{}}
+		for _, assertion := range suite.assertionsFor(file.Path) {
+			outcome := &AssertionResult{Name: assertion.Name()}
+
+			if file.Status != scopes.FileDone {
+				outcome.Message = "no response"
+			} else if passed, reason := assertion.check(file.Response, judge); passed {
+				outcome.Passed = true
+			} else {
+				outcome.Message = reason
+			}
+
+			fileResult.Assertions = append(fileResult.Assertions, outcome)
+		}
+		result.Files = append(result.Files, fileResult)
+	}
+
+	return result, nil
+}
+
+// LoadResult loads a result saved as a baseline
+func LoadResult(path string) (*Result, error) {
+	const op = "eval.LoadResult"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errMsg := fmt.Sprintf("Baseline %s doesn't exist", path)
+		return nil, ez.New(op, ez.ENOTFOUND, errMsg, err)
+	}
+
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse baseline "+path, err)
+	}
+
+	return &result, nil
+}
+
+// Save writes the result so it can be used as a baseline
+func (r *Result) Save(path string) error {
+	const op = "Result.Save"
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error marshaling result", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error creating baseline directory", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing baseline "+path, err)
+	}
+
+	return nil
+}
+
+// Count returns the amount of assertions that passed and the total
+func (r *Result) Count() (int, int) {
+	passed, total := 0, 0
+	for _, file := range r.Files {
+		for _, assertion := range file.Assertions {
+			total++
+			if assertion.Passed {
+				passed++
+			}
+		}
+	}
+	return passed, total
+}
+
+// PassRate returns the percentage of assertions that passed
+func (r *Result) PassRate() float64 {
+	passed, total := r.Count()
+	if total == 0 {
+		return 0
+	}
+	return float64(passed) * 100 / float64(total)
+}
+
+// Compare returns the assertions whose outcome changed since the baseline.
+// Assertions of the baseline missing from the result are regressions, e.g. when
+// a file of the suite was skipped
+func (r *Result) Compare(baseline *Result) *Diff {
+	before := baseline.outcomes()
+	after := r.outcomes()
+	diff := &Diff{}
+
+	for key, passed := range after {
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case previous && !passed:
+			diff.Regressions = append(diff.Regressions, key)
+		case !previous && passed:
+			diff.Improvements = append(diff.Improvements, key)
+		}
+	}
+
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Regressions = append(diff.Regressions, key+" (missing)")
+		}
+	}
+
+	sort.Strings(diff.Regressions)
+	sort.Strings(diff.Improvements)
+	sort.Strings(diff.Added)
+
+	return diff
+}
+
+// outcomes returns whether each assertion passed by file and name
+func (r *Result) outcomes() map[string]bool {
+	outcomes := make(map[string]bool)
+	for _, file := range r.Files {
+		for _, assertion := range file.Assertions {
+			outcomes[file.Path+": "+assertion.Name] = assertion.Passed
+		}
+	}
+	return outcomes
+}
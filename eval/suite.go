@@ -0,0 +1,238 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/prompts"
+	"github.com/vanclief/coderunner/scopes"
+	"github.com/vanclief/ez"
+)
+
+const (
+	AssertContains    = "contains"
+	AssertNotContains = "not-contains"
+	AssertRegex       = "regex"
+	AssertJSONPath    = "json-path"
+	AssertJudge       = "judge"
+)
+
+// Suite is a prompt evaluated over a set of files with the assertions every
+// response must satisfy
+type Suite struct {
+	Name       string      `json:"name"`
+	Prompt     string      `json:"prompt,omitempty"`
+	Template   string      `json:"template,omitempty"` // File with the prompt, relative to the suite
+	Use        string      `json:"use,omitempty"`      // Prompt of the prompt library
+	System     string      `json:"system,omitempty"`
+	Model      string      `json:"model,omitempty"`
+	JudgeModel string      `json:"judgeModel,omitempty"` // Defaults to the model
+	Scope      string      `json:"scope,omitempty"`
+	Files      []string    `json:"files,omitempty"`
+	Assertions []Assertion `json:"assertions,omitempty"` // Checked on every file
+	Cases      []Case      `json:"cases,omitempty"`
+
+	path string
+}
+
+// Case holds the assertions of a single file
+type Case struct {
+	File       string      `json:"file"`
+	Assertions []Assertion `json:"assertions"`
+}
+
+// Assertion is a check on a response
+type Assertion struct {
+	Type   string          `json:"type"`
+	Value  string          `json:"value,omitempty"`  // Text or expression of contains, not-contains and regex
+	Path   string          `json:"path,omitempty"`   // Path of json-path, e.g. findings[0].ruleId
+	Equals json.RawMessage `json:"equals,omitempty"` // Expected value of json-path, if not set the path must exist
+	Rubric string          `json:"rubric,omitempty"` // Criteria of judge
+}
+
+// Find returns the path of a suite, either a file or the name of a suite in
+// coderunner/evals
+func Find(name string) (string, error) {
+	const op = "eval.Find"
+
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+
+	if filepath.Ext(name) == "" && !strings.ContainsRune(name, filepath.Separator) {
+		path := filepath.Join(files.PROJECT_DIR, files.EVALS_DIR, name+".json")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	errMsg := fmt.Sprintf("Eval suite %s doesn't exist", name)
+	return "", ez.Root(op, ez.ENOTFOUND, errMsg)
+}
+
+// Load reads and validates a suite file
+func Load(path string) (*Suite, error) {
+	const op = "eval.Load"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ez.New(op, ez.ENOTFOUND, "Failed to read eval suite "+path, err)
+	}
+
+	var suite Suite
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse eval suite "+path, err)
+	}
+	suite.path = path
+
+	if suite.Name == "" {
+		suite.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	if err := suite.validate(); err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return &suite, nil
+}
+
+// BaselinePath returns the default path of the baseline of the suite
+func (s *Suite) BaselinePath() string {
+	return strings.TrimSuffix(s.path, filepath.Ext(s.path)) + ".baseline.json"
+}
+
+func (s *Suite) validate() error {
+	const op = "Suite.validate"
+
+	sources := 0
+	for _, source := range []string{s.Prompt, s.Template, s.Use} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return ez.New(op, ez.EINVALID, "The suite needs exactly one of prompt, template or use", nil)
+	}
+
+	if s.Scope != "" && len(s.Files) > 0 {
+		return ez.New(op, ez.EINVALID, "The suite can't have both a scope and files", nil)
+	}
+
+	assertions := append([]Assertion{}, s.Assertions...)
+	for _, c := range s.Cases {
+		if c.File == "" {
+			return ez.New(op, ez.EINVALID, "Every case needs a file", nil)
+		}
+		assertions = append(assertions, c.Assertions...)
+	}
+
+	if len(assertions) == 0 {
+		return ez.New(op, ez.EINVALID, "The suite has no assertions", nil)
+	}
+
+	for _, assertion := range assertions {
+		if err := assertion.validate(); err != nil {
+			return ez.Wrap(op, err)
+		}
+	}
+
+	return nil
+}
+
+// RunOptions builds the options of the run of the suite, resolving its prompt
+func (s *Suite) RunOptions() (scopes.RunOptions, error) {
+	const op = "Suite.RunOptions"
+
+	options := scopes.RunOptions{
+		Prompt:      s.Prompt,
+		PromptName:  s.Use,
+		System:      s.System,
+		Model:       s.Model,
+		KeepGoing:   true,
+		DiffContext: scopes.DefaultDiffContext,
+	}
+
+	if s.Template != "" {
+		path := s.Template
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(s.path), path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return options, ez.New(op, ez.ENOTFOUND, "Failed to read template "+path, err)
+		}
+		options.TemplateFile = path
+		options.Prompt = string(data)
+	}
+
+	if s.Use != "" {
+		p, err := prompts.Load(s.Use)
+		if err != nil {
+			return options, ez.Wrap(op, err)
+		}
+
+		options.Prompt = p.Body
+		if options.System == "" {
+			options.System = p.System
+		}
+		if options.Model == "" {
+			options.Model = p.Model
+		}
+	}
+
+	if options.Model == "" {
+		options.Model = "sonnet"
+	}
+
+	return options, nil
+}
+
+// LoadScope returns the scope of the suite, either a saved scope or one made
+// of the files of the suite and its cases
+func (s *Suite) LoadScope() (*scopes.Scope, error) {
+	const op = "Suite.LoadScope"
+
+	if s.Scope != "" {
+		scope, err := scopes.LoadScope(s.Scope)
+		if err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+		return scope, nil
+	}
+
+	scope := scopes.NewScope("eval-"+s.Name, "")
+	paths := append([]string{}, s.Files...)
+	for _, c := range s.Cases {
+		paths = append(paths, c.File)
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			errMsg := fmt.Sprintf("File %s of the suite doesn't exist", path)
+			return nil, ez.New(op, ez.ENOTFOUND, errMsg, err)
+		}
+		scope.AddToMap(filepath.ToSlash(filepath.Clean(path)), true)
+	}
+
+	if len(paths) == 0 {
+		return nil, ez.New(op, ez.EINVALID, "The suite needs a scope or files", nil)
+	}
+
+	return scope, nil
+}
+
+// assertionsFor returns the assertions checked on a file
+func (s *Suite) assertionsFor(path string) []Assertion {
+	assertions := append([]Assertion{}, s.Assertions...)
+	for _, c := range s.Cases {
+		if filepath.Clean(c.File) == filepath.Clean(path) {
+			assertions = append(assertions, c.Assertions...)
+		}
+	}
+	return assertions
+}
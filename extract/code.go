@@ -0,0 +1,60 @@
+package extract
+
+import (
+	"strings"
+)
+
+// CodeBlock is a fenced code block found in a response
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// CodeBlocks returns every fenced code block of a markdown text in order
+func CodeBlocks(text string) []CodeBlock {
+	blocks := make([]CodeBlock, 0)
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var current *CodeBlock
+	var fence string
+	var code []string
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if current == nil {
+			if marker := fenceMarker(trimmed); marker != "" {
+				fence = marker
+				current = &CodeBlock{Language: strings.TrimSpace(trimmed[len(marker):])}
+				code = make([]string, 0)
+			}
+			continue
+		}
+
+		// A closing fence is at least as long as the opening one and has no info string
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(code, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+
+		code = append(code, line)
+	}
+
+	return blocks
+}
+
+// fenceMarker returns the backtick or tilde fence that opens a code block
+func fenceMarker(line string) string {
+	for _, char := range []string{"`", "~"} {
+		count := 0
+		for count < len(line) && string(line[count]) == char {
+			count++
+		}
+		if count >= 3 {
+			return line[:count]
+		}
+	}
+	return ""
+}
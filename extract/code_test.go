@@ -0,0 +1,73 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []CodeBlock
+	}{
+		{
+			name: "no blocks",
+			text: "Just text",
+			want: []CodeBlock{},
+		},
+		{
+			name: "single block",
+			text: "Here:\n```go\npackage main\n```\nDone",
+			want: []CodeBlock{{Language: "go", Code: "package main"}},
+		},
+		{
+			name: "several blocks in order",
+			text: "```go\na\n```\ntext\n```\nb\n```",
+			want: []CodeBlock{{Language: "go", Code: "a"}, {Language: "", Code: "b"}},
+		},
+		{
+			name: "info string",
+			text: "```go title=main.go\na\n```",
+			want: []CodeBlock{{Language: "go title=main.go", Code: "a"}},
+		},
+		{
+			name: "longer fence holds a shorter one",
+			text: "````md\n```go\na\n```\n````",
+			want: []CodeBlock{{Language: "md", Code: "```go\na\n```"}},
+		},
+		{
+			name: "tilde fence",
+			text: "~~~python\nprint(1)\n~~~",
+			want: []CodeBlock{{Language: "python", Code: "print(1)"}},
+		},
+		{
+			name: "indented fence keeps the code indentation",
+			text: "  ```go\n\tx := 1\n  ```",
+			want: []CodeBlock{{Language: "go", Code: "\tx := 1"}},
+		},
+		{
+			name: "windows line endings",
+			text: "```go\r\na\r\n```\r\n",
+			want: []CodeBlock{{Language: "go", Code: "a"}},
+		},
+		{
+			name: "unclosed block is dropped",
+			text: "```go\na\n",
+			want: []CodeBlock{},
+		},
+		{
+			name: "empty block",
+			text: "```\n```",
+			want: []CodeBlock{{Language: "", Code: ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CodeBlocks(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CodeBlocks() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+const (
+	KindCode    = "code"     // First code block
+	KindCodeAll = "code-all" // Every code block
+	KindJSON    = "json"     // First valid JSON value
+)
+
+// Validate returns an error if an extract spec is unknown. A spec is a kind
+// optionally followed by a language, e.g. code:go
+func Validate(spec string) error {
+	const op = "extract.Validate"
+
+	kind, language, _ := strings.Cut(spec, ":")
+	switch kind {
+	case KindCode, KindCodeAll:
+		return nil
+	case KindJSON:
+		if language == "" {
+			return nil
+		}
+	}
+
+	errMsg := fmt.Sprintf("Invalid extract %s, expected code, code:<lang>, code-all, code-all:<lang> or json", spec)
+	return ez.New(op, ez.EINVALID, errMsg, nil)
+}
+
+// Apply returns the part of a text selected by an extract spec, it fails if
+// nothing matches
+func Apply(spec, text string) (string, error) {
+	const op = "extract.Apply"
+
+	kind, language, _ := strings.Cut(spec, ":")
+
+	if kind == KindJSON {
+		value, ok := JSON(text)
+		if !ok {
+			return "", ez.Root(op, ez.ENOTFOUND, "No valid JSON in the response")
+		}
+		return value, nil
+	}
+
+	blocks := make([]string, 0)
+	for _, block := range CodeBlocks(text) {
+		if language == "" || strings.EqualFold(blockLanguage(block), language) {
+			blocks = append(blocks, block.Code)
+		}
+	}
+
+	if len(blocks) == 0 {
+		if language != "" {
+			return "", ez.Root(op, ez.ENOTFOUND, fmt.Sprintf("No %s code block in the response", language))
+		}
+		return "", ez.Root(op, ez.ENOTFOUND, "No code block in the response")
+	}
+
+	if kind == KindCode {
+		return blocks[0] + "\n", nil
+	}
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+// JSON returns the first valid JSON value of a text, looking first in json
+// code blocks, then in any code block and finally in the whole text
+func JSON(text string) (string, bool) {
+	blocks := CodeBlocks(text)
+
+	candidates := make([]string, 0, len(blocks)+1)
+	for _, block := range blocks {
+		if blockLanguage(block) == "json" {
+			candidates = append(candidates, block.Code)
+		}
+	}
+	for _, block := range blocks {
+		candidates = append(candidates, block.Code)
+	}
+	candidates = append(candidates, text)
+
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate != "" && json.Valid([]byte(candidate)) {
+			return candidate + "\n", true
+		}
+	}
+
+	return "", false
+}
+
+// blockLanguage returns the language of a block without the rest of its info
+// string
+func blockLanguage(block CodeBlock) string {
+	fields := strings.Fields(block.Language)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
@@ -0,0 +1,93 @@
+package extract
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "code"},
+		{spec: "code:go"},
+		{spec: "code-all"},
+		{spec: "code-all:sql"},
+		{spec: "json"},
+		{spec: "json:go", wantErr: true},
+		{spec: "yaml", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			err := Validate(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	response := "Sure:\n```go\npackage a\n```\nand\n```SQL\nSELECT 1;\n```\n```go\npackage b\n```"
+
+	tests := []struct {
+		name    string
+		spec    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "first block", spec: "code", text: response, want: "package a\n"},
+		{name: "first block of a language", spec: "code:sql", text: response, want: "SELECT 1;\n"},
+		{name: "every block", spec: "code-all", text: response, want: "package a\n\nSELECT 1;\n\npackage b\n"},
+		{name: "every block of a language", spec: "code-all:go", text: response, want: "package a\n\npackage b\n"},
+		{name: "missing language", spec: "code:python", text: response, wantErr: true},
+		{name: "no blocks", spec: "code", text: "No code", wantErr: true},
+		{name: "json", spec: "json", text: "```json\n{\"a\": 1}\n```", want: "{\"a\": 1}\n"},
+		{name: "no json", spec: "json", text: "nothing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply(tt.spec, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{name: "json block", text: "```json\n[1, 2]\n```", want: "[1, 2]\n", wantOK: true},
+		{name: "json block with info string", text: "```JSON title\n{}\n```", want: "{}\n", wantOK: true},
+		{
+			name:   "json block first",
+			text:   "```\n{\"other\": true}\n```\n```json\n{\"json\": true}\n```",
+			want:   "{\"json\": true}\n",
+			wantOK: true,
+		},
+		{name: "any block", text: "```\n{\"a\": 1}\n```", want: "{\"a\": 1}\n", wantOK: true},
+		{name: "invalid json block falls back", text: "```json\n{oops}\n```\n```\n[3]\n```", want: "[3]\n", wantOK: true},
+		{name: "whole text", text: "  {\"a\": 1}  ", want: "{\"a\": 1}\n", wantOK: true},
+		{name: "no json", text: "The answer is yes", wantOK: false},
+		{name: "empty", text: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := JSON(tt.text)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("JSON() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
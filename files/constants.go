@@ -1,3 +1,24 @@
 package files
 
 const CODERUNNER_DIR = ".coderunner"
+
+const RUNS_DIR = "runs"
+
+const PATCHES_DIR = "patches"
+
+// PROJECT_DIR holds the coderunner files that are tracked with the project
+const PROJECT_DIR = "coderunner"
+
+const PROMPTS_DIR = "prompts"
+
+const CONFIG_FILE = "config.json"
+
+const CHATS_DIR = "chats"
+
+const OUT_DIR = "out"
+
+// EVALS_DIR holds the eval suites inside the project directory
+const EVALS_DIR = "evals"
+
+// POLICY_FILE controls what can be sent to which providers
+const POLICY_FILE = "policy.json"
@@ -0,0 +1,78 @@
+package files
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// ValidateGlob returns an error if a glob pattern is malformed
+func ValidateGlob(pattern string) error {
+	const op = "files.ValidateGlob"
+
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil || pattern == "" {
+			errMsg := fmt.Sprintf("Invalid glob %s", pattern)
+			return ez.New(op, ez.EINVALID, errMsg, err)
+		}
+	}
+
+	return nil
+}
+
+// MatchGlob reports whether a path matches a glob. ** matches any amount of
+// directories, a pattern ending in / matches everything inside a directory and
+// a pattern without / matches any file or directory name, like in .gitignore
+func MatchGlob(pattern, filePath string) bool {
+	parts := strings.Split(filePath, "/")
+
+	dirOnly := strings.HasSuffix(pattern, "/")
+	name := strings.TrimSuffix(pattern, "/")
+
+	if !strings.Contains(name, "/") {
+		for i, part := range parts {
+			// Directory patterns never match the file name
+			if dirOnly && i == len(parts)-1 {
+				break
+			}
+			if ok, _ := path.Match(name, part); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if dirOnly {
+		name += "/**"
+	}
+
+	return matchSegments(strings.Split(strings.TrimPrefix(name, "/"), "/"), parts)
+}
+
+// matchSegments matches the segments of a pattern against the ones of a path
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], parts[1:])
+}
@@ -0,0 +1,66 @@
+package files
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "*.go", path: "main.go", want: true},
+		{pattern: "*.go", path: "cmd/main.go", want: true},
+		{pattern: "*.go", path: "main.go.txt", want: false},
+		{pattern: "vendor", path: "vendor/lib/a.go", want: true},
+		{pattern: "vendor", path: "src/vendor/a.go", want: true},
+		{pattern: "migrations/", path: "db/migrations/001.sql", want: true},
+		{pattern: "migrations/", path: "migrations", want: false},
+		{pattern: "cmd/*.go", path: "cmd/main.go", want: true},
+		{pattern: "cmd/*.go", path: "cmd/sub/main.go", want: false},
+		{pattern: "cmd/*.go", path: "src/cmd/main.go", want: false},
+		{pattern: "/cmd/*.go", path: "cmd/main.go", want: true},
+		{pattern: "**/*_test.go", path: "a_test.go", want: true},
+		{pattern: "**/*_test.go", path: "pkg/deep/a_test.go", want: true},
+		{pattern: "**/*_test.go", path: "pkg/a.go", want: false},
+		{pattern: "pkg/**", path: "pkg/a.go", want: true},
+		{pattern: "pkg/**", path: "pkg/deep/a.go", want: true},
+		{pattern: "pkg/**", path: "other/a.go", want: false},
+		{pattern: "pkg/**/a.go", path: "pkg/a.go", want: true},
+		{pattern: "pkg/**/a.go", path: "pkg/x/y/a.go", want: true},
+		{pattern: "pkg/**/a.go", path: "pkg/x/y/b.go", want: false},
+		{pattern: "docs/", path: "docs/guide/intro.md", want: true},
+		{pattern: "docs/api/", path: "docs/api/index.md", want: true},
+		{pattern: "docs/api/", path: "docs/guide/index.md", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := MatchGlob(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "*.go"},
+		{pattern: "pkg/**/a.go"},
+		{pattern: "migrations/"},
+		{pattern: "[a-z].go"},
+		{pattern: "[a-.go", wantErr: true},
+		{pattern: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := ValidateGlob(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateGlob(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package files
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var languagesByExtension = map[string]string{
+	".go":    "go",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cpp":   "cpp",
+	".cc":    "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".sh":    "bash",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".md":    "markdown",
+	".proto": "protobuf",
+}
+
+// DetectLanguage returns the language of a file based on its extension, it
+// returns an empty string if the language is unknown
+func DetectLanguage(path string) string {
+	base := filepath.Base(path)
+	if base == "Dockerfile" {
+		return "dockerfile"
+	} else if base == "Makefile" {
+		return "makefile"
+	}
+
+	return languagesByExtension[strings.ToLower(filepath.Ext(path))]
+}
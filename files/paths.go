@@ -2,6 +2,7 @@ package files
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/vanclief/coderunner/git"
 	"github.com/vanclief/ez"
@@ -29,3 +30,23 @@ func GetCommitFilePath(fileName, fileExtension string) (string, error) {
 
 	return fmt.Sprintf("%s/%s.%s.%s", CODERUNNER_DIR, gitInfo.CurrentCommit, fileName, fileExtension), nil
 }
+
+// GetRunDirPath returns the directory where the files of a run are stored
+func GetRunDirPath(runID string) string {
+	return filepath.Join(CODERUNNER_DIR, RUNS_DIR, runID)
+}
+
+// GetPatchDirPath returns the directory where the patches of a run are saved
+func GetPatchDirPath(runID string) string {
+	return filepath.Join(CODERUNNER_DIR, PATCHES_DIR, runID)
+}
+
+// GetChatFilePath returns the path of a file of a chat transcript
+func GetChatFilePath(chatID, fileExtension string) string {
+	return filepath.Join(CODERUNNER_DIR, CHATS_DIR, chatID+"."+fileExtension)
+}
+
+// GetOutDirPath returns the default directory where the responses of a run are saved
+func GetOutDirPath(runID string) string {
+	return filepath.Join(CODERUNNER_DIR, OUT_DIR, runID)
+}
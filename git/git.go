@@ -1,6 +1,7 @@
 package git
 
 import (
+	"fmt"
 	"os/exec"
 	"strings"
 
@@ -90,3 +91,219 @@ func getCurrentBranch() (string, error) {
 	}
 	return strings.TrimSpace(string(output)), nil
 }
+
+// GetFileDiff returns the unified diff of a file between two commits. If target
+// is empty the base commit is compared against the working tree
+func GetFileDiff(base, target, path string, contextLines int) (string, error) {
+	const op = "git.GetFileDiff"
+
+	revision := base
+	if target != "" {
+		revision = base + ".." + target
+	}
+
+	cmd := exec.Command("git", "diff", fmt.Sprintf("--unified=%d", contextLines), revision, "--", path)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "failed to get diff of "+path, err)
+	}
+	return string(output), nil
+}
+
+// DiffFiles returns the unified diff between two files, they don't need to be
+// part of a repository
+func DiffFiles(oldPath, newPath string, color bool) (string, error) {
+	const op = "git.DiffFiles"
+
+	colorFlag := "--color=never"
+	if color {
+		colorFlag = "--color=always"
+	}
+
+	cmd := exec.Command("git", "diff", "--no-index", colorFlag, "--", oldPath, newPath)
+	output, err := cmd.Output()
+	if err != nil {
+		// git diff exits with 1 when the files are different
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return "", ez.New(op, ez.EINTERNAL, "failed to diff "+oldPath, err)
+		}
+	}
+	return string(output), nil
+}
+
+// ApplyPatch runs git apply with a patch file. The output of git is returned as
+// the error message so it can be shown or fed back to an LLM
+func ApplyPatch(patchPath string, args ...string) error {
+	const op = "git.ApplyPatch"
+
+	cmd := exec.Command("git", append(append([]string{"apply"}, args...), patchPath)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = "failed to apply patch"
+		}
+		return ez.New(op, ez.EINVALID, message, err)
+	}
+	return nil
+}
+
+// ShowFile returns the content of a file at the given commit
+func ShowFile(commit, path string) (string, error) {
+	const op = "git.ShowFile"
+
+	cmd := exec.Command("git", "show", commit+":"+path)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", ez.New(op, ez.ENOTFOUND, fmt.Sprintf("failed to read %s at %s", path, commit), err)
+	}
+	return string(output), nil
+}
+
+// GetRepositoryURI returns the URL of the origin remote, or the file URI of the
+// repository root when there is no remote
+func GetRepositoryURI() (string, error) {
+	const op = "git.GetRepositoryURI"
+
+	output, err := exec.Command("git", "config", "--get", "remote.origin.url").Output()
+	if err == nil && strings.TrimSpace(string(output)) != "" {
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	output, err = exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "failed to get repository root", err)
+	}
+	return "file://" + strings.TrimSpace(string(output)) + "/", nil
+}
+
+// GetStagedDiff returns the diff of the changes staged for the next commit
+func GetStagedDiff() (string, error) {
+	const op = "git.GetStagedDiff"
+
+	output, err := exec.Command("git", "diff", "--cached").Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "failed to get staged diff", err)
+	}
+	return string(output), nil
+}
+
+// GetDiff returns the diff between two commits
+func GetDiff(base, target string) (string, error) {
+	const op = "git.GetDiff"
+
+	output, err := exec.Command("git", "diff", base+".."+target).Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, fmt.Sprintf("failed to get diff %s..%s", base, target), err)
+	}
+	return string(output), nil
+}
+
+// GetLogSubjects returns the subjects of the most recent commits
+func GetLogSubjects(count int) ([]string, error) {
+	output, err := exec.Command("git", "log", fmt.Sprintf("-%d", count), "--format=%s").Output()
+	if err != nil {
+		// A repository without commits has no log
+		return []string{}, nil
+	}
+
+	return splitLines(string(output)), nil
+}
+
+// GetHooksPath returns the directory of the git hooks of the repository
+func GetHooksPath() (string, error) {
+	const op = "git.GetHooksPath"
+
+	output, err := exec.Command("git", "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "failed to get hooks path", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetMergeBase returns the best common ancestor of two commits
+func GetMergeBase(a, b string) (string, error) {
+	const op = "git.GetMergeBase"
+
+	output, err := exec.Command("git", "merge-base", a, b).Output()
+	if err != nil {
+		return "", ez.New(op, ez.ENOTFOUND, fmt.Sprintf("failed to find the merge base of %s and %s", a, b), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetCommitLog returns the abbreviated hash and subject of the commits between
+// two commits, oldest first
+func GetCommitLog(base, target string) ([]string, error) {
+	const op = "git.GetCommitLog"
+
+	output, err := exec.Command("git", "log", "--reverse", "--format=%h %s", base+".."+target).Output()
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, fmt.Sprintf("failed to get log %s..%s", base, target), err)
+	}
+	return splitLines(string(output)), nil
+}
+
+// GetDiffStat returns the diffstat between two commits
+func GetDiffStat(base, target string) (string, error) {
+	const op = "git.GetDiffStat"
+
+	output, err := exec.Command("git", "diff", "--stat", base+".."+target).Output()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, fmt.Sprintf("failed to get diffstat %s..%s", base, target), err)
+	}
+	return string(output), nil
+}
+
+// GetChangedFiles returns the paths of the files changed between two commits
+func GetChangedFiles(base, target string) ([]string, error) {
+	const op = "git.GetChangedFiles"
+
+	output, err := exec.Command("git", "diff", "--name-only", "-z", base+".."+target).Output()
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, fmt.Sprintf("failed to get changed files %s..%s", base, target), err)
+	}
+	return splitNull(string(output)), nil
+}
+
+// GetChangedSince returns the paths of the files changed since a commit,
+// including uncommitted changes and untracked files. Paths are relative to the
+// working directory, like the paths of a scope, and only files under it are
+// returned
+func GetChangedSince(ref string) ([]string, error) {
+	const op = "git.GetChangedSince"
+
+	output, err := exec.Command("git", "diff", "--name-only", "--relative", "-z", ref, "--").Output()
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("failed to get the files changed since %s", ref), err)
+	}
+
+	untracked, err := exec.Command("git", "ls-files", "--others", "--exclude-standard", "-z").Output()
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "failed to list untracked files", err)
+	}
+
+	return append(splitNull(string(output)), splitNull(string(untracked))...), nil
+}
+
+// splitNull splits the output of a command run with -z, whose paths are not
+// quoted
+func splitNull(output string) []string {
+	paths := []string{}
+	for _, path := range strings.Split(output, "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func splitLines(output string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,175 @@
+package gofile
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// Merge adds the declarations and imports of generated to existing without
+// touching the existing code. Declarations whose names already exist are not
+// added, their names are returned as skipped
+func Merge(existing, generated string) (string, []string, error) {
+	const op = "gofile.Merge"
+
+	oldSet := token.NewFileSet()
+	oldFile, err := parser.ParseFile(oldSet, "existing.go", existing, parser.ParseComments)
+	if err != nil {
+		return "", nil, ez.New(op, ez.EINVALID, "Failed to parse the existing file", err)
+	}
+
+	newSet := token.NewFileSet()
+	newFile, err := parser.ParseFile(newSet, "generated.go", generated, parser.ParseComments)
+	if err != nil {
+		return "", nil, ez.New(op, ez.EINVALID, "Failed to parse the generated file: "+err.Error(), err)
+	}
+
+	if oldFile.Name.Name != newFile.Name.Name {
+		errMsg := fmt.Sprintf("The generated file is in package %s but the existing file is in package %s",
+			newFile.Name.Name, oldFile.Name.Name)
+		return "", nil, ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+
+	declared := make(map[string]bool)
+	for _, decl := range oldFile.Decls {
+		for _, name := range declNames(decl) {
+			declared[name] = true
+		}
+	}
+
+	skipped := []string{}
+	var added strings.Builder
+
+	for _, decl := range newFile.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+
+		conflict := ""
+		for _, name := range declNames(decl) {
+			if declared[name] {
+				conflict = name
+				break
+			}
+		}
+		if conflict != "" {
+			skipped = append(skipped, conflict)
+			continue
+		}
+
+		start := decl.Pos()
+		if doc := declDoc(decl); doc != nil {
+			start = doc.Pos()
+		}
+		fmt.Fprintf(&added, "\n%s\n", generated[newSet.Position(start).Offset:newSet.Position(decl.End()).Offset])
+	}
+
+	imported := make(map[string]bool)
+	for _, spec := range oldFile.Imports {
+		imported[spec.Path.Value] = true
+	}
+
+	missing := []string{}
+	for _, spec := range newFile.Imports {
+		if !imported[spec.Path.Value] {
+			missing = append(missing, generated[newSet.Position(spec.Pos()).Offset:newSet.Position(spec.End()).Offset])
+		}
+	}
+
+	merged := insertImports(existing, oldSet, oldFile, missing) + added.String()
+
+	formatted, err := format.Source([]byte(merged))
+	if err != nil {
+		return "", nil, ez.New(op, ez.EINTERNAL, "Failed to format the merged file", err)
+	}
+
+	return string(formatted), skipped, nil
+}
+
+// insertImports adds import specs to the first import block of a file, or in a
+// new block after the package clause
+func insertImports(source string, fset *token.FileSet, file *ast.File, specs []string) string {
+	if len(specs) == 0 {
+		return source
+	}
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		if gen.Lparen.IsValid() {
+			offset := fset.Position(gen.Rparen).Offset
+			return source[:offset] + "\t" + strings.Join(specs, "\n\t") + "\n" + source[offset:]
+		}
+
+		// Turn the single import into a block with the new specs
+		start, end := fset.Position(gen.Specs[0].Pos()).Offset, fset.Position(gen.End()).Offset
+		specs = append([]string{source[start:end]}, specs...)
+		return source[:fset.Position(gen.Pos()).Offset] + "import (\n\t" + strings.Join(specs, "\n\t") + "\n)" + source[end:]
+	}
+
+	offset := fset.Position(file.Name.End()).Offset
+	return source[:offset] + "\n\nimport (\n\t" + strings.Join(specs, "\n\t") + "\n)" + source[offset:]
+}
+
+// declNames returns the names declared by a top level declaration, methods are
+// named after their receiver
+func declNames(decl ast.Decl) []string {
+	names := []string{}
+
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		name := d.Name.Name
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			name = receiverName(d.Recv.List[0].Type) + "." + name
+		}
+		names = append(names, name)
+
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					if name.Name != "_" {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
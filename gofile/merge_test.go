@@ -0,0 +1,84 @@
+package gofile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    string
+		generated   string
+		want        string
+		wantSkipped []string
+		wantErr     bool
+	}{
+		{
+			name:      "adds new functions and imports to an import block",
+			existing:  "package a\n\nimport (\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) {}\n",
+			generated: "package a\n\nimport (\n\t\"strings\"\n\t\"testing\"\n)\n\n// TestB checks b\nfunc TestB(t *testing.T) { _ = strings.TrimSpace(\"\") }\n",
+			want: "package a\n\nimport (\n\t\"strings\"\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) {}\n\n" +
+				"// TestB checks b\nfunc TestB(t *testing.T) { _ = strings.TrimSpace(\"\") }\n",
+			wantSkipped: []string{},
+		},
+		{
+			name:        "skips existing declarations",
+			existing:    "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+			generated:   "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) { t.Fail() }\n\nfunc TestB(t *testing.T) {}\n",
+			want:        "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n\nfunc TestB(t *testing.T) {}\n",
+			wantSkipped: []string{"TestA"},
+		},
+		{
+			name:        "turns a single import into a block",
+			existing:    "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+			generated:   "package a\n\nimport \"fmt\"\n\nvar x = fmt.Sprint(1)\n",
+			want:        "package a\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) {}\n\nvar x = fmt.Sprint(1)\n",
+			wantSkipped: []string{},
+		},
+		{
+			name:        "adds imports to a file without them",
+			existing:    "package a\n\nvar y = 2\n",
+			generated:   "package a\n\nimport \"fmt\"\n\nvar x = fmt.Sprint(1)\n",
+			want:        "package a\n\nimport (\n\t\"fmt\"\n)\n\nvar y = 2\n\nvar x = fmt.Sprint(1)\n",
+			wantSkipped: []string{},
+		},
+		{
+			name:        "methods are named after their receiver",
+			existing:    "package a\n\ntype T struct{}\n\nfunc (t *T) Name() string { return \"t\" }\n",
+			generated:   "package a\n\ntype U struct{}\n\nfunc (u U) Name() string { return \"u\" }\n\nfunc (t T) Name() string { return \"\" }\n",
+			want:        "package a\n\ntype T struct{}\n\nfunc (t *T) Name() string { return \"t\" }\n\ntype U struct{}\n\nfunc (u U) Name() string { return \"u\" }\n",
+			wantSkipped: []string{"T.Name"},
+		},
+		{
+			name:      "different package",
+			existing:  "package a\n",
+			generated: "package b\n",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid generated file",
+			existing:  "package a\n",
+			generated: "package a\n\nfunc {",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped, err := Merge(tt.existing, tt.generated)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Merge() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("Merge() skipped = %q, want %q", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
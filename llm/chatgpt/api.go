@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/sashabaranov/go-openai"
+	"github.com/vanclief/coderunner/llm"
 	"github.com/vanclief/ez"
 )
 
@@ -38,26 +39,81 @@ func NewAPI(apiKey, model string) (*API, error) {
 	return api, nil
 }
 
-func (a *API) Prompt(prompt string) (string, error) {
-	const op = "chatgpt.Prompt"
+func (a *API) Send(req *llm.Request) (*llm.Response, error) {
+	const op = "chatgpt.Send"
+
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.Messages)+2)
+	if req.System != "" && !a.rejectsSystem() {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: req.System,
+		})
+	}
+
+	// OpenAI caches repeated prefixes automatically, sending the context first
+	// makes it part of the cached prefix
+	if req.Context != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: req.Context,
+		})
+	}
+
+	for _, message := range req.Messages {
+		role := openai.ChatMessageRoleUser
+		if message.Role == llm.RoleAssistant {
+			role = openai.ChatMessageRoleAssistant
+		}
+
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: message.Content,
+		})
+	}
+
+	// The system prompt goes before the first user message for models without
+	// system messages
+	if req.System != "" && a.rejectsSystem() {
+		for i := range messages {
+			if messages[i].Role == openai.ChatMessageRoleUser {
+				messages[i].Content = req.System + "\n\n" + messages[i].Content
+				break
+			}
+		}
+	}
 
 	resp, err := a.client.CreateChatCompletion(
 		context.Background(),
 		openai.ChatCompletionRequest{
-			Model: a.Model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
+			Model:    a.Model,
+			Messages: messages,
 		},
 	)
 	if err != nil {
-		return "", ez.Wrap(op, err)
+		return nil, ez.Wrap(op, err)
 	} else if len(resp.Choices) == 0 {
-		return "", ez.New(op, ez.ENOTFOUND, "No choices in response", nil)
+		return nil, ez.New(op, ez.ENOTFOUND, "No choices in response", nil)
+	}
+
+	usage := llm.Usage{
+		InputTokens:  resp.Usage.PromptTokens,
+		OutputTokens: resp.Usage.CompletionTokens,
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	if resp.Usage.PromptTokensDetails != nil {
+		usage.CacheReadTokens = resp.Usage.PromptTokensDetails.CachedTokens
+		usage.InputTokens -= usage.CacheReadTokens
+	}
+
+	return &llm.Response{
+		Content:   resp.Choices[0].Message.Content,
+		Model:     resp.Model,
+		Usage:     usage,
+		Truncated: resp.Choices[0].FinishReason == openai.FinishReasonLength,
+	}, nil
+}
+
+// rejectsSystem returns true for the models that don't accept system messages
+func (a *API) rejectsSystem() bool {
+	return a.Model == openai.O1Preview || a.Model == openai.O1Mini
 }
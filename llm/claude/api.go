@@ -2,7 +2,6 @@ package claude
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/vanclief/coderunner/llm"
 	"github.com/vanclief/ez"
 )
 
@@ -32,12 +32,23 @@ type API struct {
 }
 
 type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content []textBlock `json:"content"`
+}
+
+type textBlock struct {
+	Type         string        `json:"type"`
+	Text         string        `json:"text"`
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+type cacheControl struct {
+	Type string `json:"type"`
 }
 
 type request struct {
 	Model     string    `json:"model"`
+	System    string    `json:"system,omitempty"`
 	Messages  []message `json:"messages"`
 	MaxTokens int       `json:"max_tokens"`
 }
@@ -50,6 +61,14 @@ type response struct {
 	Model        string         `json:"model"`
 	StopReason   string         `json:"stop_reason"`
 	StopSequence string         `json:"stop_sequence"`
+	Usage        usage          `json:"usage"`
+}
+
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
 type contentBlock struct {
@@ -85,7 +104,7 @@ func NewAPI(apiKey, model string, maxTokens int) (*API, error) {
 		Model:           model,
 		BaseURL:         "https://api.anthropic.com/v1/messages",
 		MaxTokens:       maxTokens,
-		client:          &http.Client{Timeout: 30 * time.Second},
+		client:          &http.Client{Timeout: 10 * time.Minute}, // Long responses can take minutes
 		remainingTokens: float64(TokensPerMinute),
 		nextRefill:      time.Now().Add(RefillInterval),
 	}
@@ -121,36 +140,49 @@ func (a *API) waitForTokens(requiredTokens float64) {
 	}
 }
 
-func (a *API) estimateTokens(prompt string) float64 {
-	return float64(len(prompt)) * EstimatedTokensPerChar
+func (a *API) estimateTokens(req *llm.Request) float64 {
+	chars := len(req.System) + len(req.Context)
+	for _, message := range req.Messages {
+		chars += len(message.Content)
+	}
+	return float64(chars) * EstimatedTokensPerChar
 }
 
-func (a *API) Prompt(prompt string) (string, error) {
-	requiredTokens := a.estimateTokens(prompt)
+func (a *API) Send(req *llm.Request) (*llm.Response, error) {
+	requiredTokens := a.estimateTokens(req)
 	a.waitForTokens(requiredTokens)
 
-	req := request{
-		Model: a.Model,
-		Messages: []message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+	body := request{
+		Model:     a.Model,
+		System:    req.System,
+		Messages:  make([]message, 0, len(req.Messages)),
 		MaxTokens: a.MaxTokens,
 	}
 
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %w", err)
+	for i, m := range req.Messages {
+		blocks := []textBlock{{Type: "text", Text: m.Content}}
+
+		// The context goes in its own block before the first message so it can
+		// be cached between requests
+		if i == 0 && req.Context != "" {
+			contextBlock := textBlock{Type: "text", Text: req.Context, CacheControl: &cacheControl{Type: "ephemeral"}}
+			blocks = append([]textBlock{contextBlock}, blocks...)
+		}
+
+		body.Messages = append(body.Messages, message{
+			Role:    m.Role,
+			Content: blocks,
+		})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.BaseURL, bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequest("POST", a.BaseURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -159,13 +191,13 @@ func (a *API) Prompt(prompt string) (string, error) {
 
 	resp, err := a.client.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("error making request: %w", err)
+		return nil, fmt.Errorf("error making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %w", err)
+		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -174,10 +206,10 @@ func (a *API) Prompt(prompt string) (string, error) {
 			a.mutex.Lock()
 			a.remainingTokens = 0
 			a.mutex.Unlock()
-			return a.Prompt(prompt)
+			return a.Send(req)
 		}
 
-		return "", &APIError{
+		return nil, &APIError{
 			StatusCode: resp.StatusCode,
 			Status:     resp.Status,
 			Body:       string(bodyBytes),
@@ -187,14 +219,24 @@ func (a *API) Prompt(prompt string) (string, error) {
 
 	var apiResponse response
 	if err := json.Unmarshal(bodyBytes, &apiResponse); err != nil {
-		return "", fmt.Errorf("error decoding response: %w\nResponse body: %s", err, string(bodyBytes))
+		return nil, fmt.Errorf("error decoding response: %w\nResponse body: %s", err, string(bodyBytes))
 	}
 
 	if len(apiResponse.Content) > 0 {
-		return apiResponse.Content[0].Text, nil
+		return &llm.Response{
+			Content: apiResponse.Content[0].Text,
+			Model:   apiResponse.Model,
+			Usage: llm.Usage{
+				InputTokens:      apiResponse.Usage.InputTokens,
+				OutputTokens:     apiResponse.Usage.OutputTokens,
+				CacheReadTokens:  apiResponse.Usage.CacheReadInputTokens,
+				CacheWriteTokens: apiResponse.Usage.CacheCreationInputTokens,
+			},
+			Truncated: apiResponse.StopReason == "max_tokens",
+		}, nil
 	}
 
-	return "", fmt.Errorf("no content in response")
+	return nil, fmt.Errorf("no content in response")
 }
 
 func min(a, b float64) float64 {
@@ -1,5 +1,65 @@
 package llm
 
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a conversation sent to a model. Context is shared by many requests
+// (e.g. pinned files) and is sent before the first message, providers that
+// support prompt caching cache it
+type Request struct {
+	System   string    `json:"system,omitempty"`
+	Context  string    `json:"context,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// NewRequest creates a request with a single user prompt
+func NewRequest(system, context, prompt string) *Request {
+	return &Request{
+		System:  system,
+		Context: context,
+		Messages: []Message{
+			{Role: RoleUser, Content: prompt},
+		},
+	}
+}
+
+// Usage holds the amount of tokens consumed by a request
+type Usage struct {
+	InputTokens      int `json:"inputTokens"` // Input tokens that were not read from or written to the cache
+	OutputTokens     int `json:"outputTokens"`
+	CacheReadTokens  int `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int `json:"cacheWriteTokens,omitempty"`
+}
+
+// Add accumulates the usage of another request
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheReadTokens += other.CacheReadTokens
+	u.CacheWriteTokens += other.CacheWriteTokens
+}
+
+// Total returns every token consumed, cached or not
+func (u Usage) Total() int {
+	return u.InputTokens + u.OutputTokens + u.CacheReadTokens + u.CacheWriteTokens
+}
+
+// Response is the answer of a model to a request
+type Response struct {
+	Content   string `json:"content"`
+	Model     string `json:"model"`
+	Usage     Usage  `json:"usage"`
+	Truncated bool   `json:"truncated,omitempty"` // The model stopped at its max output tokens
+}
+
 type API interface {
-	Prompt(prompt string) (string, error)
+	Send(req *Request) (*Response, error)
 }
@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+)
+
+// OllamaPrefix selects a local Ollama model, e.g. ollama:qwen2.5-coder
+const OllamaPrefix = "ollama:"
+
+// Model describes a model that can be selected with --model, prices are in USD
+// per million tokens
+type Model struct {
+	Name            string // Name used in the CLI
+	ID              string // Name used by the provider
+	Provider        string
+	ContextWindow   int
+	MaxOutputTokens int
+	InputPrice      float64
+	OutputPrice     float64
+	CacheReadPrice  float64
+	CacheWritePrice float64
+	CharsPerToken   float64 // Average characters per token of the model tokenizer
+}
+
+var models = map[string]*Model{
+	"sonnet": {
+		Name:            "sonnet",
+		ID:              "claude-3-5-sonnet-latest",
+		Provider:        ProviderAnthropic,
+		ContextWindow:   200000,
+		MaxOutputTokens: 8192,
+		InputPrice:      3,
+		OutputPrice:     15,
+		CacheReadPrice:  0.3,
+		CacheWritePrice: 3.75,
+		CharsPerToken:   3.5,
+	},
+	"4o": {
+		Name:            "4o",
+		ID:              "gpt-4o",
+		Provider:        ProviderOpenAI,
+		ContextWindow:   128000,
+		MaxOutputTokens: 16384,
+		InputPrice:      2.5,
+		OutputPrice:     10,
+		CacheReadPrice:  1.25,
+		CacheWritePrice: 2.5,
+		CharsPerToken:   4,
+	},
+	"o1": {
+		Name:            "o1",
+		ID:              "o1-preview",
+		Provider:        ProviderOpenAI,
+		ContextWindow:   128000,
+		MaxOutputTokens: 32768,
+		InputPrice:      15,
+		OutputPrice:     60,
+		CacheReadPrice:  7.5,
+		CacheWritePrice: 15,
+		CharsPerToken:   4,
+	},
+	"o1-mini": {
+		Name:            "o1-mini",
+		ID:              "o1-mini",
+		Provider:        ProviderOpenAI,
+		ContextWindow:   128000,
+		MaxOutputTokens: 65536,
+		InputPrice:      3,
+		OutputPrice:     12,
+		CacheReadPrice:  1.5,
+		CacheWritePrice: 3,
+		CharsPerToken:   4,
+	},
+}
+
+// LookupModel returns the description of a model by its CLI name. Ollama
+// models are free and their limits are conservative defaults
+func LookupModel(name string) (*Model, error) {
+	const op = "llm.LookupModel"
+
+	if id, ok := strings.CutPrefix(name, OllamaPrefix); ok && id != "" {
+		return &Model{
+			Name:            name,
+			ID:              id,
+			Provider:        ProviderOllama,
+			ContextWindow:   32768,
+			MaxOutputTokens: 4096,
+			CharsPerToken:   4,
+		}, nil
+	}
+
+	model, ok := models[name]
+	if !ok {
+		errMsg := fmt.Sprintf("Invalid model: %s", name)
+		return nil, ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+
+	return model, nil
+}
+
+// ModelNames returns the CLI names of every known model
+func ModelNames() []string {
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EstimateMargin is the fraction by which token estimates can be off in either
+// direction, the characters per token vary with the language and the content
+const EstimateMargin = 0.25
+
+// EstimateTokens estimates the amount of tokens of a text for the model from
+// its length. It is a heuristic, not the count of the model tokenizer
+func (m *Model) EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return int(float64(len(text))/m.CharsPerToken) + 1
+}
+
+// EstimateRequestTokens estimates the input tokens of a request
+func (m *Model) EstimateRequestTokens(req *Request) int {
+	tokens := m.EstimateTokens(req.System) + m.EstimateTokens(req.Context)
+	for _, message := range req.Messages {
+		tokens += m.EstimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// Cost returns the cost in USD of the given usage
+func (m *Model) Cost(usage Usage) float64 {
+	cost := float64(usage.InputTokens)*m.InputPrice +
+		float64(usage.OutputTokens)*m.OutputPrice +
+		float64(usage.CacheReadTokens)*m.CacheReadPrice +
+		float64(usage.CacheWriteTokens)*m.CacheWritePrice
+
+	return cost / 1000000
+}
@@ -0,0 +1,127 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// DefaultHost is the address of a local Ollama server
+const DefaultHost = "http://localhost:11434"
+
+type API struct {
+	Host   string
+	Model  string
+	client *http.Client
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type request struct {
+	Model    string    `json:"model"`
+	Messages []message `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+type response struct {
+	Model           string  `json:"model"`
+	Message         message `json:"message"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	DoneReason      string  `json:"done_reason"`
+	Error           string  `json:"error"`
+}
+
+// ResolveHost returns the URL of the Ollama server of a host, an empty host is
+// the local default
+func ResolveHost(host string) string {
+	if host == "" {
+		host = DefaultHost
+	} else if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/")
+}
+
+func NewAPI(host, model string) (*API, error) {
+	const op = "ollama.NewAPI"
+
+	if model == "" {
+		return nil, ez.New(op, ez.EINVALID, "Model cannot be empty", nil)
+	}
+
+	api := &API{
+		Host:  ResolveHost(host),
+		Model: model,
+		// Local models can take minutes to answer on large files
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}
+
+	return api, nil
+}
+
+func (a *API) Send(req *llm.Request) (*llm.Response, error) {
+	const op = "ollama.Send"
+
+	body := request{
+		Model:    a.Model,
+		Messages: make([]message, 0, len(req.Messages)+2),
+	}
+
+	if req.System != "" {
+		body.Messages = append(body.Messages, message{Role: "system", Content: req.System})
+	}
+	if req.Context != "" {
+		body.Messages = append(body.Messages, message{Role: llm.RoleUser, Content: req.Context})
+	}
+	for _, m := range req.Messages {
+		body.Messages = append(body.Messages, message{Role: m.Role, Content: m.Content})
+	}
+
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling request", err)
+	}
+
+	resp, err := a.client.Post(a.Host+"/api/chat", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, ez.New(op, ez.EUNAVAILABLE, "Error calling Ollama at "+a.Host, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading response body", err)
+	}
+
+	var apiResponse response
+	if err := json.Unmarshal(bodyBytes, &apiResponse); err != nil {
+		errMsg := fmt.Sprintf("Error decoding response (%s): %s", resp.Status, string(bodyBytes))
+		return nil, ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("Ollama error (%s): %s", resp.Status, apiResponse.Error)
+		return nil, ez.New(op, ez.EINTERNAL, errMsg, nil)
+	}
+
+	return &llm.Response{
+		Content: apiResponse.Message.Content,
+		Model:   apiResponse.Model,
+		Usage: llm.Usage{
+			InputTokens:  apiResponse.PromptEvalCount,
+			OutputTokens: apiResponse.EvalCount,
+		},
+		Truncated: apiResponse.DoneReason == "length",
+	}, nil
+}
@@ -0,0 +1,131 @@
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// Cassette holds recorded responses indexed by the hash of the model and the
+// request that produced them
+type Cassette struct {
+	Responses map[string]*llm.Response `json:"responses"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// API answers requests with the responses of a cassette. When it wraps another
+// API the requests missing from the cassette are sent to it and recorded,
+// otherwise they fail so runs never reach a provider
+type API struct {
+	Model    string
+	cassette *Cassette
+	next     llm.API
+}
+
+// LoadCassette loads a cassette file, a missing file is an empty cassette
+func LoadCassette(path string) (*Cassette, error) {
+	const op = "replay.LoadCassette"
+
+	cassette := &Cassette{Responses: make(map[string]*llm.Response), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cassette, nil
+	} else if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading cassette "+path, err)
+	}
+
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse cassette "+path, err)
+	}
+
+	if cassette.Responses == nil {
+		cassette.Responses = make(map[string]*llm.Response)
+	}
+
+	return cassette, nil
+}
+
+// Save writes the cassette to its file
+func (c *Cassette) Save() error {
+	const op = "Cassette.Save"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error marshaling cassette", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error creating cassette directory", err)
+	}
+
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing cassette "+c.path, err)
+	}
+
+	return nil
+}
+
+// NewAPI creates an API that replays the responses of a model, next is the API
+// used to record the missing ones and can be nil
+func NewAPI(cassette *Cassette, model string, next llm.API) *API {
+	return &API{Model: model, cassette: cassette, next: next}
+}
+
+func (a *API) Send(req *llm.Request) (*llm.Response, error) {
+	const op = "replay.Send"
+
+	key, err := requestKey(a.Model, req)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	a.cassette.mu.Lock()
+	response, ok := a.cassette.Responses[key]
+	a.cassette.mu.Unlock()
+
+	if ok {
+		return response, nil
+	}
+
+	if a.next == nil {
+		errMsg := fmt.Sprintf("No recorded response of %s for this request, record it again", a.Model)
+		return nil, ez.New(op, ez.ENOTFOUND, errMsg, nil)
+	}
+
+	response, err = a.next.Send(req)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	a.cassette.mu.Lock()
+	a.cassette.Responses[key] = response
+	a.cassette.mu.Unlock()
+
+	return response, nil
+}
+
+// requestKey returns the hash that identifies a request sent to a model
+func requestKey(model string, req *llm.Request) (string, error) {
+	const op = "replay.requestKey"
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error marshaling request", err)
+	}
+
+	hash := sha256.Sum256(append([]byte(model+"\n"), data...))
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -19,6 +19,11 @@ func main() {
 	app.Commands = []*cli.Command{
 		cmd.ScopeCmd(),
 		cmd.LLMCmd(),
+		cmd.PromptCmd(),
+		cmd.ChatCmd(),
+		cmd.CommitMsgCmd(),
+		cmd.PRDescriptionCmd(),
+		cmd.EvalCmd(),
 	}
 
 	err := files.Init()
@@ -35,5 +40,6 @@ func main() {
 		} else {
 			color.Red(ez.ErrorMessage(err))
 		}
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// fileChange is the part of a diff that changes a single file
+type fileChange struct {
+	paths []string // Every path the change reads or writes, e.g. both sides of a rename
+	size  int      // Bytes of the diff of the file
+	hunks bool
+}
+
+// DiffPaths returns every path changed by a git or plain unified diff, it
+// fails when a header can't be read
+func DiffPaths(diff string) ([]string, error) {
+	const op = "policy.DiffPaths"
+
+	changes, err := parseDiff(diff)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		for _, path := range change.paths {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// parseDiff reads the files changed by a git or plain unified diff. Hunks are
+// skipped by their line counts, so removed or added lines that look like
+// headers are never read as such
+func parseDiff(diff string) ([]*fileChange, error) {
+	const op = "policy.parseDiff"
+
+	changes := make([]*fileChange, 0)
+	var current *fileChange
+	oldLines, newLines := 0, 0
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if oldLines > 0 || newLines > 0 {
+			switch {
+			case strings.HasPrefix(text, "-"):
+				oldLines--
+			case strings.HasPrefix(text, "+"):
+				newLines--
+			case strings.HasPrefix(text, `\`):
+				// No newline at end of file
+			default:
+				oldLines--
+				newLines--
+			}
+			current.size += len(line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			a, b, ok := parseDiffHeader(strings.TrimPrefix(text, "diff --git "))
+			if !ok {
+				return nil, ez.Root(op, ez.EINVALID, "Can't read the paths of the diff header "+text)
+			}
+			current = &fileChange{paths: []string{a, b}}
+			changes = append(changes, current)
+
+		case strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "+++ "):
+			// Plain unified diffs start each file with its --- line
+			if strings.HasPrefix(text, "--- ") && (current == nil || current.hunks) {
+				current = &fileChange{paths: []string{}}
+				changes = append(changes, current)
+			} else if current == nil {
+				return nil, ez.Root(op, ez.EINVALID, "Diff line without a file header: "+text)
+			}
+
+			prefix := "b/"
+			if text[0] == '-' {
+				prefix = "a/"
+			}
+
+			path, ok := headerPath(text[4:], prefix)
+			if !ok {
+				return nil, ez.Root(op, ez.EINVALID, "Can't read the path of the diff line "+text)
+			}
+			if path != "" {
+				current.paths = append(current.paths, path)
+			}
+
+		case current != nil && !current.hunks && isCopyOrRename(text):
+			_, path, _ := strings.Cut(text, " ")
+			_, path, _ = strings.Cut(path, " ")
+			path, ok := headerPath(path, "")
+			if !ok || path == "" {
+				return nil, ez.Root(op, ez.EINVALID, "Can't read the path of the diff line "+text)
+			}
+			current.paths = append(current.paths, path)
+
+		case strings.HasPrefix(text, "@@"):
+			match := hunkHeader.FindStringSubmatch(text)
+			if match == nil || current == nil {
+				return nil, ez.Root(op, ez.EINVALID, "Invalid hunk header "+text)
+			}
+			oldLines, newLines = hunkLines(match[1]), hunkLines(match[2])
+			current.hunks = true
+		}
+
+		if current != nil {
+			current.size += len(line)
+		}
+	}
+
+	for _, change := range changes {
+		if len(change.paths) == 0 {
+			return nil, ez.Root(op, ez.EINVALID, "Diff without the path of a file")
+		}
+	}
+
+	return changes, nil
+}
+
+// headerPath reads the path of a ---/+++, rename or copy line, without its
+// prefix. It returns an empty path for /dev/null
+func headerPath(path, prefix string) (string, bool) {
+	if strings.HasPrefix(path, `"`) {
+		end := closingQuote(path)
+		if end < 0 {
+			return "", false
+		}
+		unquoted, err := strconv.Unquote(path[:end+1])
+		if err != nil {
+			return "", false
+		}
+		path = unquoted
+	} else if name, _, found := strings.Cut(path, "\t"); found {
+		// Plain diffs can add a timestamp after a tab
+		path = name
+	}
+
+	if path == "/dev/null" {
+		return "", true
+	}
+	if prefix != "" {
+		if !strings.HasPrefix(path, prefix) {
+			return "", false
+		}
+		path = strings.TrimPrefix(path, prefix)
+	}
+	if path == "" {
+		return "", false
+	}
+
+	return path, true
+}
+
+func isCopyOrRename(line string) bool {
+	for _, keyword := range []string{"rename from ", "rename to ", "copy from ", "copy to "} {
+		if strings.HasPrefix(line, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// hunkLines returns the line count of a hunk range, which is 1 when omitted
+func hunkLines(count string) int {
+	if count == "" {
+		return 1
+	}
+	lines, _ := strconv.Atoi(count)
+	return lines
+}
+
+// parseDiffHeader reads the paths of "a/<path> b/<path>", paths with special
+// characters are quoted by git and paths with spaces are not
+func parseDiffHeader(header string) (string, string, bool) {
+	var a, b string
+
+	if strings.HasPrefix(header, `"`) {
+		end := closingQuote(header)
+		if end < 0 {
+			return "", "", false
+		}
+		a, header = header[:end+1], strings.TrimPrefix(header[end+1:], " ")
+		b = header
+	} else if i := strings.Index(header, ` "b/`); i >= 0 {
+		a, b = header[:i], header[i+1:]
+	} else if n := len(header) / 2; len(header)%2 == 1 && header[n] == ' ' &&
+		strings.HasPrefix(header, "a/") && header[2:n] == strings.TrimPrefix(header[n+1:], "b/") {
+		// Same path on both sides, it can contain spaces
+		a, b = header[:n], header[n+1:]
+	} else if strings.Count(header, " b/") == 1 {
+		a, b, _ = strings.Cut(header, " b/")
+		b = "b/" + b
+	} else {
+		return "", "", false
+	}
+
+	for _, path := range []*string{&a, &b} {
+		if strings.HasPrefix(*path, `"`) {
+			unquoted, err := strconv.Unquote(*path)
+			if err != nil {
+				return "", "", false
+			}
+			*path = unquoted
+		}
+	}
+
+	if !strings.HasPrefix(a, "a/") || !strings.HasPrefix(b, "b/") {
+		return "", "", false
+	}
+
+	return a[2:], b[2:], true
+}
+
+// closingQuote returns the index of the quote that closes a quoted string
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiffHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		a, b   string
+		ok     bool
+	}{
+		{name: "plain", header: "a/main.go b/main.go", a: "main.go", b: "main.go", ok: true},
+		{name: "rename", header: "a/old.go b/new.go", a: "old.go", b: "new.go", ok: true},
+		{name: "same path with spaces", header: "a/my file.go b/my file.go", a: "my file.go", b: "my file.go", ok: true},
+		{name: "path containing b/", header: "a/x b/y.go b/x b/y.go", a: "x b/y.go", b: "x b/y.go", ok: true},
+		{name: "quoted", header: `"a/t\303\251.go" "b/t\303\251.go"`, a: "té.go", b: "té.go", ok: true},
+		{name: "quoted new path", header: `a/plain.go "b/with\ttab.go"`, a: "plain.go", b: "with\ttab.go", ok: true},
+		{name: "missing prefixes", header: "main.go main.go", ok: false},
+		{name: "unterminated quote", header: `"a/main.go b/main.go`, ok: false},
+		{name: "ambiguous", header: "a/x b/y b/z b/w", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b, ok := parseDiffHeader(tt.header)
+			if ok != tt.ok || a != tt.a || b != tt.b {
+				t.Errorf("parseDiffHeader(%q) = %q, %q, %v, want %q, %q, %v", tt.header, a, b, ok, tt.a, tt.b, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDiffPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		diff    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "git diff",
+			diff: "diff --git a/main.go b/main.go\n" +
+				"index 1111111..2222222 100644\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" package main\n" +
+				"-var x = 1\n" +
+				"+var x = 2\n",
+			want: []string{"main.go"},
+		},
+		{
+			name: "several files",
+			diff: "diff --git a/a.go b/a.go\n" +
+				"--- a/a.go\n" +
+				"+++ b/a.go\n" +
+				"@@ -1 +1 @@\n" +
+				"-a\n" +
+				"+b\n" +
+				"diff --git a/b.go b/b.go\n" +
+				"--- a/b.go\n" +
+				"+++ b/b.go\n" +
+				"@@ -1 +1 @@\n" +
+				"-a\n" +
+				"+b\n",
+			want: []string{"a.go", "b.go"},
+		},
+		{
+			name: "rename without hunks",
+			diff: "diff --git a/old.go b/new.go\n" +
+				"similarity index 100%\n" +
+				"rename from old.go\n" +
+				"rename to new.go\n",
+			want: []string{"old.go", "new.go"},
+		},
+		{
+			name: "new file",
+			diff: "diff --git a/new.go b/new.go\n" +
+				"new file mode 100644\n" +
+				"--- /dev/null\n" +
+				"+++ b/new.go\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+package main\n",
+			want: []string{"new.go"},
+		},
+		{
+			name: "plain unified diff",
+			diff: "--- a/main.go\t2024-01-01 00:00:00\n" +
+				"+++ b/main.go\t2024-01-01 00:00:00\n" +
+				"@@ -1 +1 @@\n" +
+				"-a\n" +
+				"+b\n" +
+				"--- a/other.go\n" +
+				"+++ b/other.go\n" +
+				"@@ -1 +1 @@\n" +
+				"-a\n" +
+				"+b\n",
+			want: []string{"main.go", "other.go"},
+		},
+		{
+			name: "hunk lines that look like headers",
+			diff: "diff --git a/main.go b/main.go\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				"--- a/secret.env\n" +
+				"+++ b/secret.env\n" +
+				" context\n",
+			want: []string{"main.go"},
+		},
+		{
+			name:    "unreadable header",
+			diff:    "diff --git main.go main.go\n",
+			wantErr: true,
+		},
+		{
+			name:    "hunk without a file",
+			diff:    "@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name:    "path without prefix",
+			diff:    "--- main.go\n+++ main.go\n@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name: "empty",
+			diff: "",
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffPaths(tt.diff)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiffPaths() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffPaths() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// ProvidersLocal allows every provider that runs on the machine
+const ProvidersLocal = "local"
+
+// Policy controls what can be sent to which providers, it is tracked with the
+// project in coderunner/policy.json
+type Policy struct {
+	DeniedPaths      []string `json:"deniedPaths,omitempty"`      // Globs of the files that are never sent to a provider
+	AllowedProviders []string `json:"allowedProviders,omitempty"` // Providers that can be used, every provider if empty
+	MaxFileSize      int64    `json:"maxFileSize,omitempty"`      // Maximum size in bytes of a file sent to a provider
+	LocalHosts       []string `json:"localHosts,omitempty"`       // Hosts besides loopback that count as local, e.g. an on-premise Ollama server
+}
+
+// Path returns the path of the policy file
+func Path() string {
+	return filepath.Join(files.PROJECT_DIR, files.POLICY_FILE)
+}
+
+// Load reads and validates the policy, a missing file allows everything
+func Load() (*Policy, error) {
+	const op = "policy.Load"
+
+	policy := &Policy{}
+
+	data, err := os.ReadFile(Path())
+	if os.IsNotExist(err) {
+		return policy, nil
+	} else if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading policy file", err)
+	}
+
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse policy file "+Path(), err)
+	}
+
+	for _, pattern := range policy.DeniedPaths {
+		if err := files.ValidateGlob(pattern); err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+	}
+
+	for _, provider := range policy.AllowedProviders {
+		switch provider {
+		case ProvidersLocal, llm.ProviderAnthropic, llm.ProviderOpenAI, llm.ProviderOllama:
+		default:
+			errMsg := fmt.Sprintf("Invalid provider %s in %s, expected local, %s, %s or %s",
+				provider, Path(), llm.ProviderAnthropic, llm.ProviderOpenAI, llm.ProviderOllama)
+			return nil, ez.New(op, ez.EINVALID, errMsg, nil)
+		}
+	}
+
+	return policy, nil
+}
+
+// CheckProvider returns an error if the policy doesn't allow the provider, host
+// is the URL of self-hosted providers. A provider is only local when its host
+// is loopback or one of the localHosts of the policy
+func (p *Policy) CheckProvider(provider, host string) error {
+	const op = "Policy.CheckProvider"
+
+	if len(p.AllowedProviders) == 0 {
+		return nil
+	}
+
+	for _, allowed := range p.AllowedProviders {
+		if allowed == provider || allowed == ProvidersLocal && p.isLocal(provider, host) {
+			return nil
+		}
+	}
+
+	target := "provider " + provider
+	if host != "" {
+		target += " at " + host
+	}
+
+	errMsg := fmt.Sprintf("Blocked by the allowedProviders rule of %s: %s is not one of %s",
+		Path(), target, strings.Join(p.AllowedProviders, ", "))
+	return ez.Root(op, ez.EINVALID, errMsg)
+}
+
+// CheckPath returns an error if a file can't be sent to a provider
+func (p *Policy) CheckPath(path string) error {
+	const op = "Policy.CheckPath"
+
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, pattern := range p.DeniedPaths {
+		if files.MatchGlob(pattern, path) {
+			errMsg := fmt.Sprintf("Blocked by the deniedPaths rule %q of %s: %s can't be sent to a provider", pattern, Path(), path)
+			return ez.Root(op, ez.EINVALID, errMsg)
+		}
+	}
+
+	return nil
+}
+
+// CheckFile returns an error if a file or its content can't be sent to a
+// provider
+func (p *Policy) CheckFile(path string, content []byte) error {
+	const op = "Policy.CheckFile"
+
+	if err := p.CheckPath(path); err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if p.MaxFileSize > 0 && int64(len(content)) > p.MaxFileSize {
+		errMsg := fmt.Sprintf("Blocked by the maxFileSize rule of %s: %s has %d bytes, the maximum is %d",
+			Path(), path, len(content), p.MaxFileSize)
+		return ez.Root(op, ez.EINVALID, errMsg)
+	}
+
+	return nil
+}
+
+// CheckDiff returns an error if a diff changes a file that can't be sent to a
+// provider or whose diff is larger than the maximum file size. Diffs with a
+// file header that can't be parsed are rejected
+func (p *Policy) CheckDiff(diff string) error {
+	const op = "Policy.CheckDiff"
+
+	changes, err := parseDiff(diff)
+	if err != nil {
+		errMsg := fmt.Sprintf("Blocked by %s: %s", Path(), ez.ErrorMessage(err))
+		return ez.Root(op, ez.EINVALID, errMsg)
+	}
+
+	for _, change := range changes {
+		for _, path := range change.paths {
+			if err := p.CheckPath(path); err != nil {
+				return ez.Wrap(op, err)
+			}
+		}
+
+		if p.MaxFileSize > 0 && int64(change.size) > p.MaxFileSize {
+			errMsg := fmt.Sprintf("Blocked by the maxFileSize rule of %s: the diff of %s has %d bytes, the maximum is %d",
+				Path(), change.paths[0], change.size, p.MaxFileSize)
+			return ez.Root(op, ez.EINVALID, errMsg)
+		}
+	}
+
+	return nil
+}
+
+// isLocal returns true if a self-hosted provider runs on the machine or on one
+// of the local hosts of the policy
+func (p *Policy) isLocal(provider, host string) bool {
+	if provider != llm.ProviderOllama || host == "" {
+		return false
+	}
+
+	parsed, err := url.Parse(host)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+
+	hostname := strings.ToLower(parsed.Hostname())
+	if hostname == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, localHost := range p.LocalHosts {
+		if strings.EqualFold(localHost, hostname) || strings.EqualFold(localHost, parsed.Host) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/vanclief/coderunner/llm"
+)
+
+func TestCheckProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *Policy
+		provider string
+		host     string
+		wantErr  bool
+	}{
+		{name: "no rule", policy: &Policy{}, provider: llm.ProviderOpenAI},
+		{name: "allowed", policy: &Policy{AllowedProviders: []string{llm.ProviderAnthropic}}, provider: llm.ProviderAnthropic},
+		{name: "not allowed", policy: &Policy{AllowedProviders: []string{llm.ProviderAnthropic}}, provider: llm.ProviderOpenAI, wantErr: true},
+		{name: "local ollama", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOllama, host: "http://localhost:11434"},
+		{name: "loopback ip", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOllama, host: "http://127.0.0.1:11434"},
+		{name: "loopback ipv6", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOllama, host: "http://[::1]:11434"},
+		{name: "remote ollama", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOllama, host: "http://gpu.example.com:11434", wantErr: true},
+		{
+			name:     "local host of the policy",
+			policy:   &Policy{AllowedProviders: []string{ProvidersLocal}, LocalHosts: []string{"GPU.example.com"}},
+			provider: llm.ProviderOllama,
+			host:     "http://gpu.example.com:11434",
+		},
+		{name: "local without host", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOllama, wantErr: true},
+		{name: "hosted provider is never local", policy: &Policy{AllowedProviders: []string{ProvidersLocal}}, provider: llm.ProviderOpenAI, host: "http://localhost", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.CheckProvider(tt.provider, tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckProvider(%q, %q) error = %v, wantErr %v", tt.provider, tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	policy := &Policy{DeniedPaths: []string{".env", "secrets/**", "**/*.pem"}}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: "main.go"},
+		{path: ".env", wantErr: true},
+		{path: "./.env", wantErr: true},
+		{path: "config/.env", wantErr: true},
+		{path: "config/env.go"},
+		{path: "secrets/prod/db.json", wantErr: true},
+		{path: "certs/server.pem", wantErr: true},
+		{path: "server.pem", wantErr: true},
+		{path: "docs/../secrets/key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := policy.CheckPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	policy := &Policy{DeniedPaths: []string{".env"}, MaxFileSize: 10}
+
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		wantErr bool
+	}{
+		{name: "allowed", path: "main.go", content: "package a"},
+		{name: "at the limit", path: "main.go", content: "0123456789"},
+		{name: "too large", path: "main.go", content: "01234567890", wantErr: true},
+		{name: "denied", path: ".env", content: "A=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.CheckFile(tt.path, []byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckFile(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDiff(t *testing.T) {
+	policy := &Policy{DeniedPaths: []string{"secrets/**"}, MaxFileSize: 200}
+
+	tests := []struct {
+		name    string
+		diff    string
+		wantErr bool
+	}{
+		{
+			name:    "allowed",
+			diff:    "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: false,
+		},
+		{
+			name:    "denied file",
+			diff:    "diff --git a/secrets/key b/secrets/key\n--- a/secrets/key\n+++ b/secrets/key\n@@ -1 +1 @@\n-a\n+b\n",
+			wantErr: true,
+		},
+		{
+			name:    "renamed into a denied path",
+			diff:    "diff --git a/main.go b/secrets/main.go\nrename from main.go\nrename to secrets/main.go\n",
+			wantErr: true,
+		},
+		{
+			name: "too large",
+			diff: "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-a\n+" +
+				string(make([]byte, 200)) + "\n",
+			wantErr: true,
+		},
+		{
+			name:    "unreadable",
+			diff:    "diff --git main.go main.go\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.CheckDiff(tt.diff)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckDiff() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package prompts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/ez"
+)
+
+const (
+	SourceProject = "project"
+	SourceUser    = "user"
+)
+
+const (
+	OutputPrint = "print"
+	OutputSave  = "save"
+)
+
+const promptExtension = ".md"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Prompt is a reusable prompt stored in the prompt library
+type Prompt struct {
+	Name        string
+	Source      string
+	Path        string
+	Description string
+	Model       string // Default model for the prompt
+	System      string // System prompt sent with every request
+	Output      string // Default output mode, print or save
+	Body        string
+}
+
+// ProjectDir returns the prompts directory tracked with the project
+func ProjectDir() string {
+	return filepath.Join(files.PROJECT_DIR, files.PROMPTS_DIR)
+}
+
+// UserDir returns the prompts directory of the current user
+func UserDir() (string, error) {
+	const op = "prompts.UserDir"
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error getting the user config directory", err)
+	}
+
+	return filepath.Join(configDir, "coderunner", files.PROMPTS_DIR), nil
+}
+
+// List returns every prompt of the library, project prompts take precedence
+// over user prompts with the same name. Malformed prompts are skipped with a
+// warning so they don't hide the rest of the library
+func List() ([]*Prompt, error) {
+	const op = "prompts.List"
+
+	userDir, err := UserDir()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	found := make(map[string]bool)
+	prompts := make([]*Prompt, 0)
+
+	dirs := []struct{ dir, source string }{
+		{ProjectDir(), SourceProject},
+		{userDir, SourceUser},
+	}
+
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d.dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, ez.New(op, ez.EINTERNAL, "Error reading prompts directory "+d.dir, err)
+		}
+
+		for _, entry := range entries {
+			name := strings.TrimSuffix(entry.Name(), promptExtension)
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), promptExtension) || found[name] {
+				continue
+			}
+
+			path := filepath.Join(d.dir, entry.Name())
+			prompt, err := loadFile(name, d.source, path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping prompt %s: %s\n", path, ez.ErrorMessage(err))
+				continue
+			}
+
+			found[name] = true
+			prompts = append(prompts, prompt)
+		}
+	}
+
+	sort.Slice(prompts, func(i, j int) bool {
+		return prompts[i].Name < prompts[j].Name
+	})
+
+	return prompts, nil
+}
+
+// Load returns a prompt by name, looking first in the project and then in the
+// user prompts
+func Load(name string) (*Prompt, error) {
+	const op = "prompts.Load"
+
+	path, source, err := Find(name)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	prompt, err := loadFile(name, source, path)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return prompt, nil
+}
+
+// Find returns the path and source of a prompt by name
+func Find(name string) (string, string, error) {
+	const op = "prompts.Find"
+
+	if err := validateName(name); err != nil {
+		return "", "", ez.Wrap(op, err)
+	}
+
+	projectPath := filepath.Join(ProjectDir(), name+promptExtension)
+	if _, err := os.Stat(projectPath); err == nil {
+		return projectPath, SourceProject, nil
+	}
+
+	userDir, err := UserDir()
+	if err != nil {
+		return "", "", ez.Wrap(op, err)
+	}
+
+	userPath := filepath.Join(userDir, name+promptExtension)
+	if _, err := os.Stat(userPath); err == nil {
+		return userPath, SourceUser, nil
+	}
+
+	errMsg := fmt.Sprintf("Prompt %s doesn't exist", name)
+	return "", "", ez.Root(op, ez.ENOTFOUND, errMsg)
+}
+
+// Create writes a new prompt with an empty frontmatter and returns its path
+func Create(name string, user bool) (string, error) {
+	const op = "prompts.Create"
+
+	if err := validateName(name); err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	dir := ProjectDir()
+	if user {
+		userDir, err := UserDir()
+		if err != nil {
+			return "", ez.Wrap(op, err)
+		}
+		dir = userDir
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error creating prompts directory", err)
+	}
+
+	path := filepath.Join(dir, name+promptExtension)
+	if _, err := os.Stat(path); err == nil {
+		errMsg := fmt.Sprintf("Prompt %s already exists", name)
+		return "", ez.Root(op, ez.ECONFLICT, errMsg)
+	}
+
+	template := "---\ndescription: \nmodel: \nsystem: \noutput: print\n---\n"
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error writing prompt file", err)
+	}
+
+	return path, nil
+}
+
+// loadFile reads and parses a prompt file
+func loadFile(name, source, path string) (*Prompt, error) {
+	const op = "prompts.loadFile"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading prompt file "+path, err)
+	}
+
+	prompt, err := Parse(string(data))
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	prompt.Name = name
+	prompt.Source = source
+	prompt.Path = path
+
+	return prompt, nil
+}
+
+// Parse parses a prompt with an optional frontmatter delimited by "---" lines
+func Parse(text string) (*Prompt, error) {
+	const op = "prompts.Parse"
+
+	prompt := &Prompt{Output: OutputPrint}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		prompt.Body = strings.TrimSpace(text)
+		return prompt, nil
+	}
+
+	frontmatter, body, ok := cutFrontmatter(text[4:])
+	if !ok {
+		return nil, ez.Root(op, ez.EINVALID, "Prompt frontmatter is not closed")
+	}
+
+	for i, line := range strings.Split(frontmatter, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			errMsg := fmt.Sprintf("Invalid frontmatter line %d: %s", i+2, line)
+			return nil, ez.Root(op, ez.EINVALID, errMsg)
+		}
+
+		value = unquote(strings.TrimSpace(value))
+
+		switch strings.TrimSpace(key) {
+		case "description":
+			prompt.Description = value
+		case "model":
+			prompt.Model = value
+		case "system":
+			prompt.System = value
+		case "output":
+			if value != "" {
+				prompt.Output = value
+			}
+		default:
+			errMsg := fmt.Sprintf("Unknown frontmatter key: %s", strings.TrimSpace(key))
+			return nil, ez.Root(op, ez.EINVALID, errMsg)
+		}
+	}
+
+	if prompt.Output != OutputPrint && prompt.Output != OutputSave {
+		errMsg := fmt.Sprintf("Invalid output mode %s, expected print or save", prompt.Output)
+		return nil, ez.Root(op, ez.EINVALID, errMsg)
+	}
+
+	prompt.Body = strings.TrimSpace(body)
+
+	return prompt, nil
+}
+
+// cutFrontmatter splits the text that follows the opening "---" line at the
+// closing "---" line, which is the first line when the frontmatter is empty
+func cutFrontmatter(text string) (string, string, bool) {
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.TrimSuffix(line, "\n") == "---" {
+			return text[:offset], text[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return "", "", false
+}
+
+func validateName(name string) error {
+	const op = "prompts.validateName"
+
+	if !validName.MatchString(name) {
+		errMsg := fmt.Sprintf("Invalid prompt name %s, use only letters, numbers, - and _", name)
+		return ez.Root(op, ez.EINVALID, errMsg)
+	}
+
+	return nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 {
+		if (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'') {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
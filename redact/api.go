@@ -0,0 +1,32 @@
+package redact
+
+import (
+	"github.com/vanclief/coderunner/llm"
+)
+
+// API redacts the secrets of every request before sending it to the wrapped
+// API and restores them in the responses
+type API struct {
+	next     llm.API
+	redactor *Redactor
+}
+
+// NewAPI wraps an API so no secret reaches its provider
+func NewAPI(next llm.API, redactor *Redactor) *API {
+	return &API{next: next, redactor: redactor}
+}
+
+func (a *API) Send(req *llm.Request) (*llm.Response, error) {
+	redacted := *req
+	a.redactor.RedactRequest(&redacted)
+
+	response, err := a.next.Send(&redacted)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := *response
+	restored.Content = a.redactor.Restore(response.Content)
+
+	return &restored, nil
+}
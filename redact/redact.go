@@ -0,0 +1,200 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// MinEntropy is the minimum Shannon entropy in bits per character of a value
+// assigned to a secret-looking name for it to be redacted
+const MinEntropy = 3.5
+
+// detector finds secrets of a kind, when the expression has a group named
+// secret only that part of the match is redacted
+type detector struct {
+	name       string
+	expression *regexp.Regexp
+	entropy    bool // Only redact values with a high entropy
+}
+
+var builtinDetectors = []*detector{
+	{name: "private-key", expression: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{name: "aws-access-key", expression: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "aws-secret-key", expression: regexp.MustCompile(`(?i)aws[\w.-]{0,20}?(?:secret|key)[\w.-]*["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})\b`)},
+	{name: "github-token", expression: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "anthropic-key", expression: regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{name: "openai-key", expression: regexp.MustCompile(`\bsk-(?:proj-|svcacct-)?[A-Za-z0-9_-]{20,}`)},
+	{
+		name:       "high-entropy",
+		expression: regexp.MustCompile(`(?i)[\w.-]*(?:secret|token|passw(?:or)?d|api_?key|access_?key|credential|auth)[\w.-]*["']?\s*[:=]\s*["']?(?P<secret>[^\s"'` + "`" + `,;()]{12,})`),
+		entropy:    true,
+	},
+}
+
+// Redactor replaces secrets with numbered placeholders, a secret gets the same
+// placeholder in every request of the redactor so the secrets can be restored
+// in the responses. Placeholders carry nothing derived from the secret
+type Redactor struct {
+	detectors []*detector
+
+	mu           sync.Mutex
+	secrets      map[string]string // Secret by placeholder
+	placeholders map[string]string // Placeholder by secret
+}
+
+// New creates a redactor with the built-in detectors and custom expressions,
+// a custom expression with a group only redacts its first group
+func New(custom []string) (*Redactor, error) {
+	const op = "redact.New"
+
+	detectors := append([]*detector{}, builtinDetectors...)
+	for _, expression := range custom {
+		compiled, err := regexp.Compile(expression)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid redact pattern %s", expression)
+			return nil, ez.New(op, ez.EINVALID, errMsg, err)
+		}
+		detectors = append(detectors, &detector{name: "custom", expression: compiled})
+	}
+
+	return &Redactor{
+		detectors:    detectors,
+		secrets:      make(map[string]string),
+		placeholders: make(map[string]string),
+	}, nil
+}
+
+// Redact replaces the secrets of a text, it returns the amount of secrets
+// redacted by detector
+func (r *Redactor) Redact(text string) (string, map[string]int) {
+	counts := make(map[string]int)
+
+	for _, d := range r.detectors {
+		group := 0
+		if index := d.expression.SubexpIndex("secret"); index > 0 {
+			group = index
+		} else if d.expression.NumSubexp() > 0 {
+			group = 1
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, match := range d.expression.FindAllStringSubmatchIndex(text, -1) {
+			start, end := match[2*group], match[2*group+1]
+			if start < 0 || start < last {
+				continue
+			}
+
+			secret := text[start:end]
+			if strings.HasPrefix(secret, placeholderPrefix) || d.entropy && entropy(secret) < MinEntropy {
+				continue
+			}
+
+			b.WriteString(text[last:start])
+			b.WriteString(r.placeholder(d.name, secret))
+			last = end
+			counts[d.name]++
+		}
+
+		if last > 0 {
+			b.WriteString(text[last:])
+			text = b.String()
+		}
+	}
+
+	return text, counts
+}
+
+// RedactRequest redacts every part of a request in place
+func (r *Redactor) RedactRequest(req *llm.Request) map[string]int {
+	counts := make(map[string]int)
+	add := func(text string) string {
+		redacted, found := r.Redact(text)
+		for name, count := range found {
+			counts[name] += count
+		}
+		return redacted
+	}
+
+	req.System = add(req.System)
+	req.Context = add(req.Context)
+	messages := make([]llm.Message, len(req.Messages))
+	for i, message := range req.Messages {
+		messages[i] = llm.Message{Role: message.Role, Content: add(message.Content)}
+	}
+	req.Messages = messages
+
+	return counts
+}
+
+// Restore replaces the placeholders of a text with the secrets they stand for
+func (r *Redactor) Restore(text string) string {
+	if !strings.Contains(text, placeholderPrefix) {
+		return text
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for placeholder, secret := range r.secrets {
+		text = strings.ReplaceAll(text, placeholder, secret)
+	}
+	return text
+}
+
+const placeholderPrefix = "[REDACTED:"
+
+// placeholder returns the placeholder of a secret, e.g. [REDACTED:github-token:1]
+func (r *Redactor) placeholder(name, secret string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if placeholder, ok := r.placeholders[secret]; ok {
+		return placeholder
+	}
+
+	placeholder := fmt.Sprintf("%s%s:%d]", placeholderPrefix, name, len(r.secrets)+1)
+	r.secrets[placeholder] = secret
+	r.placeholders[secret] = placeholder
+
+	return placeholder
+}
+
+// entropy returns the Shannon entropy of a text in bits per character
+func entropy(text string) float64 {
+	frequencies := make(map[rune]int)
+	for _, char := range text {
+		frequencies[char]++
+	}
+
+	total := float64(len([]rune(text)))
+	bits := 0.0
+	for _, count := range frequencies {
+		p := float64(count) / total
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// Summary describes the amount of secrets redacted by detector, e.g.
+// "2 aws-access-key, 1 private-key"
+func Summary(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,112 @@
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/ez"
+)
+
+// Finding is a problem reported by the LLM in a review
+type Finding struct {
+	RuleID    string `json:"ruleId"`
+	Severity  string `json:"severity"` // error, warning or note
+	Message   string `json:"message"`
+	File      string `json:"file"`
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Snippet   string `json:"snippet,omitempty"` // Code quoted from the reported lines
+}
+
+// Level returns the SARIF level of the finding, unknown severities are warnings
+func (f *Finding) Level() string {
+	switch strings.ToLower(f.Severity) {
+	case "error", "critical", "high":
+		return "error"
+	case "note", "info", "low":
+		return "note"
+	default:
+		return "warning"
+	}
+}
+
+// ParseFindings reads the findings of a response, they are expected as a JSON
+// array in a json code block or as the whole response
+func ParseFindings(response string) ([]*Finding, error) {
+	const op = "sarif.ParseFindings"
+
+	text := strings.TrimSpace(response)
+	for _, block := range extract.CodeBlocks(response) {
+		if strings.EqualFold(block.Language, "json") {
+			text = block.Code
+			break
+		}
+	}
+
+	findings := []*Finding{}
+	if err := json.Unmarshal([]byte(text), &findings); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The response is not a JSON array of findings", err)
+	}
+
+	return findings, nil
+}
+
+// CheckLines keeps the findings of a file whose lines exist in its content and
+// contain the snippet they quote. End lines past the end of the file are
+// clamped, it returns the reason each dropped finding was discarded. Findings
+// without a snippet are only checked to point at existing lines
+func CheckLines(path, content string, findings []*Finding) ([]*Finding, []string) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	lineCount := len(lines)
+	if content == "" {
+		lineCount = 0
+	}
+
+	valid := make([]*Finding, 0, len(findings))
+	dropped := []string{}
+
+	for _, finding := range findings {
+		switch {
+		case finding.File != "" && finding.File != path:
+			dropped = append(dropped, fmt.Sprintf("%s refers to another file: %s", finding.RuleID, finding.File))
+			continue
+		case finding.RuleID == "" || finding.Message == "":
+			dropped = append(dropped, "finding without ruleId or message")
+			continue
+		case finding.StartLine < 1 || finding.StartLine > lineCount:
+			dropped = append(dropped, fmt.Sprintf("%s starts at line %d, the file has %d lines", finding.RuleID, finding.StartLine, lineCount))
+			continue
+		case finding.EndLine != 0 && finding.EndLine < finding.StartLine:
+			dropped = append(dropped, fmt.Sprintf("%s ends at line %d before it starts at line %d", finding.RuleID, finding.EndLine, finding.StartLine))
+			continue
+		}
+
+		finding.File = path
+		if finding.EndLine == 0 {
+			finding.EndLine = finding.StartLine
+		} else if finding.EndLine > lineCount {
+			finding.EndLine = lineCount
+		}
+
+		if !containsSnippet(lines[finding.StartLine-1:finding.EndLine], finding.Snippet) {
+			dropped = append(dropped, fmt.Sprintf("%s quotes code that is not in lines %d-%d", finding.RuleID, finding.StartLine, finding.EndLine))
+			continue
+		}
+
+		valid = append(valid, finding)
+	}
+
+	return valid, dropped
+}
+
+// containsSnippet returns true if the lines contain the snippet, ignoring
+// differences in whitespace. An empty snippet is always contained
+func containsSnippet(lines []string, snippet string) bool {
+	snippet = strings.Join(strings.Fields(snippet), " ")
+	if snippet == "" {
+		return true
+	}
+	return strings.Contains(strings.Join(strings.Fields(strings.Join(lines, "\n")), " "), snippet)
+}
@@ -0,0 +1,141 @@
+package sarif
+
+import "testing"
+
+func TestParseFindings(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantCount int
+		wantErr   bool
+	}{
+		{name: "json block", response: "Found one:\n```json\n[{\"ruleId\": \"a\"}]\n```\n", wantCount: 1},
+		{name: "uppercase json block", response: "```JSON\n[{\"ruleId\": \"a\"}, {\"ruleId\": \"b\"}]\n```", wantCount: 2},
+		{name: "whole response", response: "  [{\"ruleId\": \"a\"}]\n", wantCount: 1},
+		{name: "empty array", response: "```json\n[]\n```", wantCount: 0},
+		{name: "not json", response: "No findings.", wantErr: true},
+		{name: "object instead of array", response: "```json\n{\"ruleId\": \"a\"}\n```", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := ParseFindings(tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFindings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(findings) != tt.wantCount {
+				t.Errorf("ParseFindings() returned %d findings, want %d", len(findings), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestCheckLines(t *testing.T) {
+	content := "package main\n\nfunc main() {\n\tpassword := \"hunter2\"\n\tprintln(password)\n}\n"
+
+	tests := []struct {
+		name        string
+		finding     Finding
+		wantValid   bool
+		wantEndLine int
+	}{
+		{
+			name:        "valid with snippet",
+			finding:     Finding{RuleID: "secret", Message: "m", StartLine: 4, Snippet: `password := "hunter2"`},
+			wantValid:   true,
+			wantEndLine: 4,
+		},
+		{
+			name:        "snippet with other whitespace",
+			finding:     Finding{RuleID: "secret", Message: "m", StartLine: 4, EndLine: 5, Snippet: "\"hunter2\"\n    println(password)"},
+			wantValid:   true,
+			wantEndLine: 5,
+		},
+		{
+			name:        "without snippet",
+			finding:     Finding{RuleID: "r", Message: "m", StartLine: 3},
+			wantValid:   true,
+			wantEndLine: 3,
+		},
+		{
+			name:        "end line clamped",
+			finding:     Finding{RuleID: "r", Message: "m", StartLine: 5, EndLine: 40},
+			wantValid:   true,
+			wantEndLine: 6,
+		},
+		{
+			name:      "snippet not in the lines",
+			finding:   Finding{RuleID: "secret", Message: "m", StartLine: 1, Snippet: "hunter2"},
+			wantValid: false,
+		},
+		{
+			name:      "start past the end",
+			finding:   Finding{RuleID: "r", Message: "m", StartLine: 7},
+			wantValid: false,
+		},
+		{
+			name:      "start before the first line",
+			finding:   Finding{RuleID: "r", Message: "m", StartLine: 0},
+			wantValid: false,
+		},
+		{
+			name:      "inverted range",
+			finding:   Finding{RuleID: "r", Message: "m", StartLine: 5, EndLine: 4},
+			wantValid: false,
+		},
+		{
+			name:      "other file",
+			finding:   Finding{RuleID: "r", Message: "m", File: "other.go", StartLine: 1},
+			wantValid: false,
+		},
+		{
+			name:      "missing rule",
+			finding:   Finding{Message: "m", StartLine: 1},
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finding := tt.finding
+			valid, dropped := CheckLines("main.go", content, []*Finding{&finding})
+
+			if gotValid := len(valid) == 1; gotValid != tt.wantValid {
+				t.Fatalf("CheckLines() valid = %v, want %v, dropped %q", gotValid, tt.wantValid, dropped)
+			}
+			if len(valid)+len(dropped) != 1 {
+				t.Errorf("CheckLines() returned %d valid and %d dropped, want 1 in total", len(valid), len(dropped))
+			}
+			if tt.wantValid && (valid[0].EndLine != tt.wantEndLine || valid[0].File != "main.go") {
+				t.Errorf("CheckLines() = %s:%d, want main.go:%d", valid[0].File, valid[0].EndLine, tt.wantEndLine)
+			}
+		})
+	}
+}
+
+func TestCheckLinesEmptyContent(t *testing.T) {
+	valid, dropped := CheckLines("empty.go", "", []*Finding{{RuleID: "r", Message: "m", StartLine: 1}})
+	if len(valid) != 0 || len(dropped) != 1 {
+		t.Errorf("CheckLines() on an empty file = %d valid, %d dropped, want 0 and 1", len(valid), len(dropped))
+	}
+}
+
+func TestLevel(t *testing.T) {
+	tests := map[string]string{
+		"error":    "error",
+		"Critical": "error",
+		"high":     "error",
+		"warning":  "warning",
+		"medium":   "warning",
+		"":         "warning",
+		"info":     "note",
+		"LOW":      "note",
+	}
+
+	for severity, want := range tests {
+		finding := &Finding{Severity: severity}
+		if got := finding.Level(); got != want {
+			t.Errorf("Level() of %q = %q, want %q", severity, got, want)
+		}
+	}
+}
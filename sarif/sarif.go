@@ -0,0 +1,154 @@
+package sarif
+
+// Version and Schema identify the SARIF format written by coderunner
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// SrcRoot is the base id the artifact paths are relative to
+const SrcRoot = "SRCROOT"
+
+// Log is the root object of a SARIF file
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []*Run `json:"runs"`
+}
+
+// Run holds the results of a single invocation of the tool
+type Run struct {
+	Tool                     Tool                     `json:"tool"`
+	Invocations              []*Invocation            `json:"invocations,omitempty"`
+	VersionControlProvenance []*VersionControlDetails `json:"versionControlProvenance,omitempty"`
+	Results                  []*Result                `json:"results"`
+}
+
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+type Driver struct {
+	Name           string  `json:"name"`
+	InformationURI string  `json:"informationUri,omitempty"`
+	Rules          []*Rule `json:"rules,omitempty"`
+}
+
+type Rule struct {
+	ID string `json:"id"`
+}
+
+type Invocation struct {
+	ExecutionSuccessful        bool            `json:"executionSuccessful"`
+	ToolExecutionNotifications []*Notification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type Notification struct {
+	Level     string      `json:"level"`
+	Message   Message     `json:"message"`
+	Locations []*Location `json:"locations,omitempty"`
+}
+
+type VersionControlDetails struct {
+	RepositoryURI string            `json:"repositoryUri"`
+	RevisionID    string            `json:"revisionId,omitempty"`
+	Branch        string            `json:"branch,omitempty"`
+	MappedTo      *ArtifactLocation `json:"mappedTo,omitempty"`
+}
+
+type Result struct {
+	RuleID    string      `json:"ruleId"`
+	RuleIndex int         `json:"ruleIndex"`
+	Level     string      `json:"level"`
+	Message   Message     `json:"message"`
+	Locations []*Location `json:"locations"`
+}
+
+type Message struct {
+	Text string `json:"text"`
+}
+
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+type ArtifactLocation struct {
+	URI       string `json:"uri,omitempty"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type Region struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+// NewLog creates an empty SARIF log
+func NewLog() *Log {
+	return &Log{Version: Version, Schema: Schema, Runs: []*Run{}}
+}
+
+// NewRun creates a run of the tool with the repository and commit it analyzed
+func NewRun(toolName, repositoryURI, commit string) *Run {
+	run := &Run{
+		Tool:        Tool{Driver: Driver{Name: toolName}},
+		Invocations: []*Invocation{{ExecutionSuccessful: true}},
+		Results:     []*Result{},
+	}
+
+	if repositoryURI != "" {
+		run.VersionControlProvenance = []*VersionControlDetails{{
+			RepositoryURI: repositoryURI,
+			RevisionID:    commit,
+			MappedTo:      &ArtifactLocation{URIBaseID: SrcRoot},
+		}}
+	}
+
+	return run
+}
+
+// AddFinding adds a finding as a result of the run, registering its rule
+func (r *Run) AddFinding(finding *Finding) {
+	index := -1
+	for i, rule := range r.Tool.Driver.Rules {
+		if rule.ID == finding.RuleID {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		r.Tool.Driver.Rules = append(r.Tool.Driver.Rules, &Rule{ID: finding.RuleID})
+		index = len(r.Tool.Driver.Rules) - 1
+	}
+
+	r.Results = append(r.Results, &Result{
+		RuleID:    finding.RuleID,
+		RuleIndex: index,
+		Level:     finding.Level(),
+		Message:   Message{Text: finding.Message},
+		Locations: []*Location{fileLocation(finding.File, &Region{StartLine: finding.StartLine, EndLine: finding.EndLine})},
+	})
+}
+
+// AddFailure records a file that could not be analyzed
+func (r *Run) AddFailure(path, message string) {
+	invocation := r.Invocations[0]
+	invocation.ExecutionSuccessful = false
+	invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, &Notification{
+		Level:     "error",
+		Message:   Message{Text: message},
+		Locations: []*Location{fileLocation(path, nil)},
+	})
+}
+
+func fileLocation(path string, region *Region) *Location {
+	return &Location{PhysicalLocation: PhysicalLocation{
+		ArtifactLocation: ArtifactLocation{URI: path, URIBaseID: SrcRoot},
+		Region:           region,
+	}}
+}
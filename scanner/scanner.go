@@ -204,13 +204,17 @@ func (s *Scanner) shouldIgnore(path string) bool {
 	}
 	relPath = filepath.ToSlash(relPath)
 
-	// Check if it's a file (not a directory)
+	// Check if it's a file (not a directory), files that don't exist are
+	// deleted files of a git diff
+	isDir := false
 	info, err := os.Stat(path)
-	if err != nil {
+	if err == nil {
+		isDir = info.IsDir()
+	} else if !os.IsNotExist(err) {
 		return true // If we can't stat the file, ignore it
 	}
 
-	if !info.IsDir() {
+	if !isDir {
 		// For scopes, check extension only if we have a whitelist
 		if len(s.allowedExtensions) > 0 {
 			ext := filepath.Ext(path)
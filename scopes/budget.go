@@ -0,0 +1,81 @@
+package scopes
+
+import (
+	"fmt"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// Spent returns the cost in USD of the files processed so far, it is zero for
+// models without known prices
+func (r *Run) Spent() float64 {
+	model, err := llm.LookupModel(r.Options.Model)
+	if err != nil {
+		return 0
+	}
+	return model.Cost(r.TotalUsage())
+}
+
+// HasBudget returns true if the run has a spend or token limit
+func (r *Run) HasBudget() bool {
+	return r.Options.Budget > 0 || r.Options.MaxTokensTotal > 0
+}
+
+// CheckBudget returns an error if the run reached its spend or token limit
+func (r *Run) CheckBudget() error {
+	const op = "Run.CheckBudget"
+
+	if r.Options.Budget > 0 && r.Spent() >= r.Options.Budget {
+		errMsg := fmt.Sprintf("Budget of $%.2f reached after spending $%.4f", r.Options.Budget, r.Spent())
+		return ez.Root(op, ez.ERESOURCEEXHAUSTED, errMsg)
+	}
+
+	if total := r.TotalUsage().Total(); r.Options.MaxTokensTotal > 0 && total >= r.Options.MaxTokensTotal {
+		errMsg := fmt.Sprintf("Limit of %d tokens reached after using %d tokens", r.Options.MaxTokensTotal, total)
+		return ez.Root(op, ez.ERESOURCEEXHAUSTED, errMsg)
+	}
+
+	return nil
+}
+
+// CheckEstimate refuses to start a run whose estimated cost or tokens already
+// exceed its limits. The lower end of the estimate minus llm.EstimateMargin is
+// used so runs are only refused when they would certainly go over
+func (s *Scope) CheckEstimate(run *Run) error {
+	const op = "Scope.CheckEstimate"
+
+	if !run.HasBudget() {
+		return nil
+	}
+
+	model, err := llm.LookupModel(run.Options.Model)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	report, err := s.DryRun(run, model)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	// What was already spent is exact, only the rest is estimated
+	minOutputCost, _ := report.OutputCostRange()
+	estimatedCost := run.Spent() + (report.InputCost()+minOutputCost)*(1-llm.EstimateMargin)
+	estimatedTokens := run.TotalUsage().Total() +
+		int(float64(report.InputTokens+report.MinOutputTokens)*(1-llm.EstimateMargin))
+
+	if run.Options.Budget > 0 && estimatedCost > run.Options.Budget {
+		errMsg := fmt.Sprintf("The estimated cost of the run (at least ~$%.4f) exceeds the budget of $%.2f, check it with --dry-run",
+			estimatedCost, run.Options.Budget)
+		return ez.Root(op, ez.ERESOURCEEXHAUSTED, errMsg)
+	}
+
+	if run.Options.MaxTokensTotal > 0 && estimatedTokens > run.Options.MaxTokensTotal {
+		errMsg := fmt.Sprintf("The estimated tokens of the run (at least ~%d) exceed the limit of %d tokens, check it with --dry-run",
+			estimatedTokens, run.Options.MaxTokensTotal)
+		return ez.Root(op, ez.ERESOURCEEXHAUSTED, errMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package scopes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/ez"
+)
+
+// Chat is a multi-turn conversation about the files of a scope
+type Chat struct {
+	ID        string        `json:"id"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Commit    string        `json:"commit"`
+	ScopeName string        `json:"scope"`
+	Model     string        `json:"model"`
+	Files     []string      `json:"files"`
+	Messages  []llm.Message `json:"messages"`
+	Usage     llm.Usage     `json:"usage"`
+	NoRedact  bool          `json:"noRedact,omitempty"` // Secrets of the files are sent to the provider
+
+	scope    *Scope
+	contents map[string]string
+}
+
+// NewChat creates a chat with the contents of the scope as context
+func NewChat(scope *Scope, model string) (*Chat, error) {
+	const op = "scopes.NewChat"
+
+	id, err := newRunID()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	gitInfo, err := git.GetInfo()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	contents, err := scope.GetFilesContent()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	chat := &Chat{
+		ID:        id,
+		CreatedAt: time.Now(),
+		Commit:    gitInfo.CurrentCommit,
+		ScopeName: scope.Name,
+		Model:     model,
+		Files:     make([]string, 0, len(contents)),
+		Messages:  []llm.Message{},
+		scope:     scope,
+		contents:  contents,
+	}
+
+	for path := range contents {
+		chat.Files = append(chat.Files, path)
+	}
+	sort.Strings(chat.Files)
+
+	return chat, nil
+}
+
+// AddFile adds a file to the context of the chat
+func (c *Chat) AddFile(path string) error {
+	const op = "Chat.AddFile"
+
+	path = filepath.Clean(path)
+	if _, ok := c.contents[path]; ok {
+		return ez.New(op, ez.ECONFLICT, fmt.Sprintf("%s is already in the context", path), nil)
+	}
+
+	content, _, err := c.scope.readFile(path)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if files.IsBinaryFile(content) {
+		return ez.New(op, ez.EINVALID, fmt.Sprintf("%s is a binary file", path), nil)
+	}
+
+	projectPolicy, err := policy.Load()
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if err := projectPolicy.CheckFile(path, content); err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	c.contents[path] = string(content)
+	c.Files = append(c.Files, path)
+	sort.Strings(c.Files)
+
+	return nil
+}
+
+// DropFile removes a file from the context of the chat
+func (c *Chat) DropFile(path string) error {
+	const op = "Chat.DropFile"
+
+	path = filepath.Clean(path)
+	if _, ok := c.contents[path]; !ok {
+		return ez.New(op, ez.ENOTFOUND, fmt.Sprintf("%s is not in the context", path), nil)
+	}
+
+	delete(c.contents, path)
+	for i, file := range c.Files {
+		if file == path {
+			c.Files = append(c.Files[:i], c.Files[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// Request builds the request for a new question with the whole history
+func (c *Chat) Request(question string) *llm.Request {
+	var context strings.Builder
+	if len(c.Files) > 0 {
+		context.WriteString("The following files are the context of the conversation.\n\n")
+	}
+	for _, path := range c.Files {
+		fmt.Fprintf(&context, "Context File: %s\n%s\n\n", path, c.contents[path])
+	}
+
+	messages := append([]llm.Message{}, c.Messages...)
+	if question != "" {
+		messages = append(messages, llm.Message{Role: llm.RoleUser, Content: question})
+	}
+
+	return &llm.Request{Context: context.String(), Messages: messages}
+}
+
+// Ask sends a question to the LLM and adds the turn to the history
+func (c *Chat) Ask(api llm.API, question string) (*llm.Response, error) {
+	const op = "Chat.Ask"
+
+	response, err := api.Send(c.Request(question))
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	c.Messages = append(c.Messages,
+		llm.Message{Role: llm.RoleUser, Content: question},
+		llm.Message{Role: llm.RoleAssistant, Content: response.Content},
+	)
+	c.Usage.Add(response.Usage)
+
+	return response, nil
+}
+
+// Save persists the chat so its transcript is kept
+func (c *Chat) Save() error {
+	const op = "Chat.Save"
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error marshaling chat", err)
+	}
+
+	return c.write(files.GetChatFilePath(c.ID, "json"), data)
+}
+
+// SaveTranscript writes the conversation as markdown and returns its path
+func (c *Chat) SaveTranscript() (string, error) {
+	const op = "Chat.SaveTranscript"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Chat %s\n\n", c.ID)
+	fmt.Fprintf(&b, "Scope `%s`, commit `%s`, model `%s`\n\n", c.ScopeName, c.Commit, c.Model)
+
+	for _, message := range c.Messages {
+		if message.Role == llm.RoleUser {
+			fmt.Fprintf(&b, "## User\n\n%s\n\n", message.Content)
+		} else {
+			fmt.Fprintf(&b, "## Assistant\n\n%s\n\n", message.Content)
+		}
+	}
+
+	path := files.GetChatFilePath(c.ID, "md")
+	if err := c.write(path, []byte(b.String())); err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	return path, nil
+}
+
+func (c *Chat) write(path string, data []byte) error {
+	const op = "Chat.write"
+
+	if err := files.EnsureDirectoryExists(filepath.Dir(path)); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error creating chats directory", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing chat file", err)
+	}
+
+	return nil
+}
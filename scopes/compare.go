@@ -0,0 +1,148 @@
+package scopes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// NewComparison creates a run per model with the same options, the runs share
+// the ID of the comparison
+func NewComparison(scope *Scope, options RunOptions, models []string) ([]*Run, error) {
+	const op = "scopes.NewComparison"
+
+	id, err := newRunID()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	runs := make([]*Run, 0, len(models))
+	for _, model := range models {
+		modelOptions := options
+		modelOptions.Model = model
+		modelOptions.Comparison = id
+
+		run, err := NewRun(scope, modelOptions)
+		if err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// ComparisonReport builds a markdown report with the latency, tokens, cost and
+// response of every model side by side for each file
+func ComparisonReport(runs []*Run) string {
+	var b strings.Builder
+
+	if len(runs) == 0 {
+		return ""
+	}
+
+	fmt.Fprintf(&b, "# Model comparison %s\n\n", runs[0].Options.Comparison)
+	fmt.Fprintf(&b, "Scope `%s`, commit `%s`\n\n", runs[0].Scope.Name, runs[0].Commit)
+
+	b.WriteString("## Totals\n\n")
+	b.WriteString("| Model | Run | Done | Failed | Avg latency | Input tokens | Output tokens | Cost |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, run := range runs {
+		usage := run.TotalUsage()
+
+		var latency time.Duration
+		if done := run.Count(FileDone); done > 0 {
+			var total int64
+			for _, file := range run.Files {
+				if file.Status == FileDone {
+					total += file.DurationMs
+				}
+			}
+			latency = time.Duration(total/int64(done)) * time.Millisecond
+		}
+
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %s | %d | %d | %s |\n", run.Options.Model, run.ID,
+			run.Count(FileDone), run.Count(FileFailed), latency, inputTokens(usage), usage.OutputTokens, formatCost(run, usage))
+	}
+	b.WriteString("\n")
+
+	for _, file := range runs[0].Files {
+		if file.Status == FileSkipped {
+			continue
+		}
+
+		fmt.Fprintf(&b, "## %s\n\n", file.Path)
+		b.WriteString("| Model | Status | Latency | Input tokens | Output tokens | Cost |\n")
+		b.WriteString("|---|---|---|---|---|---|\n")
+		for _, run := range runs {
+			modelFile, err := run.GetFile(file.Path)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(&b, "| %s | %s | %s | %d | %d | %s |\n", run.Options.Model, modelFile.Status,
+				time.Duration(modelFile.DurationMs)*time.Millisecond, inputTokens(modelFile.Usage),
+				modelFile.Usage.OutputTokens, formatCost(run, modelFile.Usage))
+		}
+		b.WriteString("\n")
+
+		for _, run := range runs {
+			modelFile, err := run.GetFile(file.Path)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(&b, "### %s\n\n", run.Options.Model)
+			switch modelFile.Status {
+			case FileDone:
+				fmt.Fprintf(&b, "%s\n\n", strings.TrimSpace(modelFile.Response))
+			case FileFailed:
+				fmt.Fprintf(&b, "**Failed:** %s\n\n", modelFile.Error)
+			default:
+				fmt.Fprintf(&b, "_%s_\n\n", modelFile.Status)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// inputTokens returns every input token of a usage, cached or not
+func inputTokens(usage llm.Usage) int {
+	return usage.InputTokens + usage.CacheReadTokens + usage.CacheWriteTokens
+}
+
+func formatCost(run *Run, usage llm.Usage) string {
+	model, err := llm.LookupModel(run.Options.Model)
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", model.Cost(usage))
+}
+
+// LoadComparison loads the runs of a model comparison in the order they were
+// created
+func LoadComparison(id string) ([]*Run, error) {
+	const op = "scopes.LoadComparison"
+
+	runs, err := ListRuns()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	comparison := make([]*Run, 0)
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Options.Comparison == id {
+			comparison = append(comparison, runs[i])
+		}
+	}
+
+	if len(comparison) == 0 {
+		return nil, ez.New(op, ez.ENOTFOUND, fmt.Sprintf("Comparison %s not found", id), nil)
+	}
+
+	return comparison, nil
+}
@@ -0,0 +1,103 @@
+package scopes
+
+import (
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// ShortOutputRatio is the ratio of output to input tokens assumed for the low
+// end of the output estimate
+const ShortOutputRatio = 0.1
+
+// DryRunFile is the request that would be sent for a file
+type DryRunFile struct {
+	Path           string
+	Request        *llm.Request
+	InputTokens    int
+	ExceedsContext bool           // The request doesn't fit in the context window of the model
+	Redactions     map[string]int // Secrets redacted from the request by detector
+	Skipped        bool
+	Error          string
+}
+
+// DryRunReport holds every request of a run with its estimated tokens and cost
+type DryRunReport struct {
+	Model           *llm.Model
+	Files           []*DryRunFile
+	InputTokens     int
+	MinOutputTokens int
+	MaxOutputTokens int
+}
+
+// DryRun builds the request of every unfinished file of the run and estimates
+// its tokens and cost without calling the provider
+func (s *Scope) DryRun(run *Run, model *llm.Model) (*DryRunReport, error) {
+	const op = "Scope.DryRun"
+
+	builder, err := s.newRequestBuilder(run)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	report := &DryRunReport{
+		Model: model,
+		Files: make([]*DryRunFile, 0, len(run.Files)),
+	}
+
+	for _, file := range run.Files {
+		if file.Status != FilePending {
+			continue
+		}
+
+		dryRunFile := &DryRunFile{Path: file.Path}
+		report.Files = append(report.Files, dryRunFile)
+
+		built, err := builder.build(file.Path)
+		if err != nil {
+			dryRunFile.Error = err.Error()
+			continue
+		} else if built == nil {
+			dryRunFile.Skipped = true
+			continue
+		}
+
+		req := built.request
+
+		tokens := model.EstimateRequestTokens(req)
+
+		dryRunFile.Request = req
+		dryRunFile.Redactions = built.redactions
+		dryRunFile.InputTokens = tokens
+		dryRunFile.ExceedsContext = tokens+model.MaxOutputTokens > model.ContextWindow
+
+		report.InputTokens += tokens
+		report.MinOutputTokens += min(int(float64(tokens)*ShortOutputRatio), model.MaxOutputTokens)
+		report.MaxOutputTokens += model.MaxOutputTokens
+	}
+
+	return report, nil
+}
+
+// InputCost returns the estimated cost of the input tokens, without prompt
+// caching discounts
+func (r *DryRunReport) InputCost() float64 {
+	return r.Model.Cost(llm.Usage{InputTokens: r.InputTokens})
+}
+
+// OutputCostRange returns the estimated cost of the output tokens, from short
+// answers to every response reaching the output limit
+func (r *DryRunReport) OutputCostRange() (float64, float64) {
+	return r.Model.Cost(llm.Usage{OutputTokens: r.MinOutputTokens}), r.Model.Cost(llm.Usage{OutputTokens: r.MaxOutputTokens})
+}
+
+// CountExceedingContext returns the amount of files that don't fit in the
+// context window of the model
+func (r *DryRunReport) CountExceedingContext() int {
+	count := 0
+	for _, file := range r.Files {
+		if file.ExceedsContext {
+			count++
+		}
+	}
+	return count
+}
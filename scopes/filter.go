@@ -0,0 +1,61 @@
+package scopes
+
+import (
+	"path/filepath"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/ez"
+)
+
+// filterPaths keeps the paths of the scope selected by the include, exclude and
+// changed since options of a run
+func (o *RunOptions) filterPaths(paths []string) ([]string, error) {
+	const op = "RunOptions.filterPaths"
+
+	if len(o.Include) == 0 && len(o.Exclude) == 0 && o.ChangedSince == "" {
+		return paths, nil
+	}
+
+	var changed map[string]bool
+	if o.ChangedSince != "" {
+		changedPaths, err := git.GetChangedSince(o.ChangedSince)
+		if err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+
+		changed = make(map[string]bool)
+		for _, changedPath := range changedPaths {
+			changed[filepath.Clean(changedPath)] = true
+		}
+	}
+
+	filtered := make([]string, 0, len(paths))
+	for _, filePath := range paths {
+		if changed != nil && !changed[filepath.Clean(filePath)] {
+			continue
+		}
+		if len(o.Include) > 0 && !matchAny(o.Include, filePath) {
+			continue
+		}
+		if matchAny(o.Exclude, filePath) {
+			continue
+		}
+		filtered = append(filtered, filePath)
+	}
+
+	if len(filtered) == 0 {
+		return nil, ez.New(op, ez.EINVALID, "No files of the scope match --include, --exclude or --changed-since", nil)
+	}
+
+	return filtered, nil
+}
+
+func matchAny(patterns []string, filePath string) bool {
+	for _, pattern := range patterns {
+		if files.MatchGlob(pattern, filePath) {
+			return true
+		}
+	}
+	return false
+}
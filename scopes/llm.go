@@ -1,46 +1,115 @@
 package scopes
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/vanclief/coderunner/config"
 	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
 	"github.com/vanclief/coderunner/llm"
 	"github.com/vanclief/coderunner/llm/chatgpt"
 	"github.com/vanclief/coderunner/llm/claude"
+	"github.com/vanclief/coderunner/llm/ollama"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/coderunner/redact"
 	"github.com/vanclief/ez"
 )
 
-type LLMCallback func(path string, response string) error
+// LLMCallback is called with every file after the LLM responds
+type LLMCallback func(file *RunFile) error
 
-func NewLLM(model string) (llm.API, error) {
+// NewLLM returns the API of a model if the policy allows its provider, with a
+// redactor the secrets of every request are redacted before reaching it
+func NewLLM(model string, redactor *redact.Redactor) (llm.API, error) {
 	const op = "files.NewLLM"
 
-	switch model {
+	modelInfo, err := llm.LookupModel(model)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	projectPolicy, err := policy.Load()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	host := ""
+	if modelInfo.Provider == llm.ProviderOllama {
+		host = ollama.ResolveHost(os.Getenv("OLLAMA_HOST"))
+	}
+
+	if err := projectPolicy.CheckProvider(modelInfo.Provider, host); err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	api, err := newProviderAPI(modelInfo)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if redactor == nil {
+		return api, nil
+	}
+
+	return redact.NewAPI(api, redactor), nil
+}
+
+// NewRedactor creates a redactor with the patterns of the project config
+func NewRedactor() (*redact.Redactor, error) {
+	const op = "scopes.NewRedactor"
+
+	projectConfig, err := config.Load()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	redactor, err := redact.New(projectConfig.RedactPatterns)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return redactor, nil
+}
+
+func newProviderAPI(model *llm.Model) (llm.API, error) {
+	const op = "scopes.newProviderAPI"
+
+	switch model.Name {
 	case "o1":
 		fallthrough
 	case "o1-mini":
 		fallthrough
 	case "4o":
-		return NewChatGPTAPI(model)
+		return NewChatGPTAPI(model.Name)
 
 	case "sonnet":
-		return NewClaudeAPI("claude-3-5-sonnet-latest")
+		return NewClaudeAPI(model.ID, model.MaxOutputTokens)
 
 	default:
-		errMsg := fmt.Sprintf("Invalid model: %s", model)
+		if model.Provider == llm.ProviderOllama {
+			return ollama.NewAPI(os.Getenv("OLLAMA_HOST"), model.ID)
+		}
+
+		errMsg := fmt.Sprintf("Invalid model: %s", model.Name)
 		return nil, ez.New(op, ez.EINVALID, errMsg, nil)
 	}
 }
 
-func NewClaudeAPI(model string) (llm.API, error) {
+func NewClaudeAPI(model string, maxTokens int) (llm.API, error) {
 	const op = "files.NewClaudeAPI"
 
 	apiKey := os.Getenv("ANTHROPIC_API_KEY")
 	if apiKey == "" {
 		return nil, ez.New(op, ez.EINTERNAL, "ANTHROPIC_API_KEY not set", nil)
 	}
-	return claude.NewAPI(apiKey, model, 2000)
+	return claude.NewAPI(apiKey, model, maxTokens)
 }
 
 func NewChatGPTAPI(model string) (llm.API, error) {
@@ -54,44 +123,353 @@ func NewChatGPTAPI(model string) (llm.API, error) {
 	return chatgpt.NewAPI(apiKey, model)
 }
 
-// Refactored process function
-func (s *Scope) RunPromptOnFiles(api llm.API, prompt string, callback LLMCallback) error {
+// RunPromptOnFiles runs the prompt of the run on every unfinished file
+func (s *Scope) RunPromptOnFiles(api llm.API, run *Run, callback LLMCallback) error {
 	const op = "Scanner.RunPromptOnFiles"
 
-	paths := s.GetAllFilePaths()
+	// Persist the run before calling the LLM so it can always be resumed
+	if err := run.Save(); err != nil {
+		return ez.Wrap(op, err)
+	}
 
-	return s.processFiles(paths, api, prompt, callback)
+	return s.processFiles(run, api, callback)
 }
 
-// Helper function for file processing logic
-func (s *Scope) processFiles(paths []string, api llm.API, prompt string, callback LLMCallback) error {
+// processFiles processes the pending files of a run with up to
+// Options.Concurrency requests in flight. Checkpoints and budget checks are
+// serialized, callbacks can run concurrently and must lock what they share
+func (s *Scope) processFiles(run *Run, api llm.API, callback LLMCallback) error {
 	const op = "Scanner.processFiles"
 
-	for _, path := range paths {
-		content, err := os.ReadFile(path)
-		if err != nil {
-			return ez.New(op, ez.EINTERNAL, "Failed to read file: "+path, err)
+	builder, err := s.newRequestBuilder(run)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	pending := make([]*RunFile, 0, len(run.Files))
+	for _, file := range run.Files {
+		if file.Status == FilePending {
+			pending = append(pending, file)
 		}
+	}
 
-		if files.IsBinaryFile(content) {
-			continue
+	tracker := newProgress(run, len(pending))
+	defer tracker.close()
+
+	var mu sync.Mutex
+	var stopErr error
+
+	jobs := make(chan *RunFile)
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(run.Options.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for file := range jobs {
+				err := s.processFile(builder, file, api, callback, tracker, &mu)
+
+				mu.Lock()
+				if err != nil {
+					file.Status = FileFailed
+					file.Error = err.Error()
+				}
+				tracker.finish(file)
+
+				saveErr := run.Save()
+				if saveErr == nil && file.Output != "" {
+					// The index lists every file, so it is written with the checkpoint
+					_, saveErr = run.WriteIndex()
+				}
+
+				if saveErr != nil && stopErr == nil {
+					stopErr = ez.Wrap(op, saveErr)
+				} else if err != nil && !run.Options.KeepGoing && stopErr == nil {
+					stopErr = ez.Wrap(op, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, file := range pending {
+		mu.Lock()
+		if stopErr == nil {
+			// Stop before going over the limits, the pending files can be resumed.
+			// Requests already in flight can still go slightly over them
+			if err := run.CheckBudget(); err != nil {
+				stopErr = ez.Wrap(op, err)
+			}
 		}
+		stop := stopErr != nil
+		mu.Unlock()
 
-		fullPrompt := fmt.Sprintf("%s\n\nFile Content:\n%s", prompt, string(content))
+		if stop {
+			break
+		}
+		jobs <- file
+	}
 
-		fmt.Print("Calling LLM... ")
-		response, err := api.Prompt(fullPrompt)
-		if err != nil {
-			fmt.Println("Failed", err)
-			return ez.New(op, ez.EINTERNAL, "LLM processing failed for file: "+path, err)
+	close(jobs)
+	wg.Wait()
+
+	return stopErr
+}
+
+// processFile runs the prompt on a single file and updates its status. Only
+// the updates of the file are done under mu, the LLM call and the callback run
+// outside of it
+func (s *Scope) processFile(builder *requestBuilder, file *RunFile, api llm.API, callback LLMCallback, tracker *progress, mu *sync.Mutex) error {
+	const op = "Scanner.processFile"
+
+	path := file.Path
+
+	// Reading the file and rendering the prompt can be slow, only the results
+	// are recorded under the lock
+	built, err := builder.build(path)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	mu.Lock()
+	if built == nil {
+		file.Status = FileSkipped
+	} else {
+		built.record(file)
+	}
+	mu.Unlock()
+
+	if built == nil {
+		return nil
+	}
+	req := built.request
+
+	startedAt := time.Now()
+	tracker.start(path)
+	response, err := api.Send(req)
+
+	// Filters can be slow, run them before taking the lock
+	var processed string
+	var processErr error
+	if err == nil {
+		if builder.redactor != nil {
+			response.Content = builder.redactor.Restore(response.Content)
 		}
+		processed, processErr = postProcess(&builder.run.Options, path, response.Content)
+	}
 
-		fmt.Println("Ok")
+	mu.Lock()
+	file.StartedAt = startedAt
+	file.DurationMs = time.Since(startedAt).Milliseconds()
+	if err == nil {
+		file.Model = response.Model
+		file.Usage = response.Usage
+		file.Truncated = response.Truncated
 
-		if err := callback(path, response); err != nil {
-			return ez.New(op, ez.EINTERNAL, "LLMCallback failed for file: "+path, err)
+		// The raw response is kept when post-processing fails so it can be inspected
+		file.Response = response.Content
+		if processErr == nil {
+			file.Response = processed
 		}
 	}
 
+	// The callback works on a copy so the checkpoints of other files never read
+	// the file while it changes
+	result := *file
+	mu.Unlock()
+
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "LLM processing failed for file: "+path, err)
+	} else if processErr != nil {
+		return ez.Wrap(op, processErr)
+	}
+
+	// Keep the status line out of the way of the callback output
+	tracker.suspend()
+	err = callback(&result)
+	tracker.resume()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	*file = result
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "LLMCallback failed for file: "+path, err)
+	}
+
+	file.Status = FileDone
+	file.Error = ""
+
 	return nil
 }
+
+// requestBuilder builds the request sent for each file of a run
+type requestBuilder struct {
+	scope    *Scope
+	run      *Run
+	prompt   *PromptTemplate
+	context  string
+	policy   *policy.Policy
+	redactor *redact.Redactor // Nil when secrets are not redacted
+}
+
+// newRequestBuilder parses the prompt and loads the context of a run
+func (s *Scope) newRequestBuilder(run *Run) (*requestBuilder, error) {
+	const op = "Scope.newRequestBuilder"
+
+	projectPolicy, err := policy.Load()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	prompt, err := NewPromptTemplate(run.Options.Prompt, projectPolicy)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if run.Options.Diff {
+		prompt.SetDiff(&DiffOptions{Context: run.Options.DiffContext, Full: run.Options.DiffFull})
+	}
+
+	context, err := s.loadContext(run.contextFiles(), projectPolicy)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	redactor, err := run.Redactor()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	builder := &requestBuilder{scope: s, run: run, prompt: prompt, context: context, policy: projectPolicy, redactor: redactor}
+
+	return builder, nil
+}
+
+// builtRequest is the request of a file with the details recorded in the run
+type builtRequest struct {
+	request     *llm.Request
+	contentHash string
+	promptHash  string
+	redactions  map[string]int
+}
+
+// record stores the request and its hashes in the file of the run
+func (r *builtRequest) record(file *RunFile) {
+	file.ContentHash = r.contentHash
+	file.PromptHash = r.promptHash
+	file.Redactions = r.redactions
+	file.Request = r.request
+}
+
+// build builds the request of a file without changing it, so it can run while
+// other files are saved. It returns nil for binary files that must be skipped
+func (b *requestBuilder) build(path string) (*builtRequest, error) {
+	const op = "requestBuilder.build"
+
+	content, deleted, err := b.scope.readFile(path)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if err := b.policy.CheckFile(path, content); err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if files.IsBinaryFile(content) {
+		return nil, nil
+	}
+
+	contentHash := sha256.Sum256(content)
+	built := &builtRequest{contentHash: hex.EncodeToString(contentHash[:])}
+
+	data := b.scope.newPromptData(path, string(content), deleted, b.run.Options.DiffContext)
+
+	fullPrompt, err := b.prompt.Render(data)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	built.request = llm.NewRequest(b.run.Options.System, b.context, fullPrompt)
+
+	// Redact here too so the secrets are reported per file, the API of the run
+	// shares the redactor and finds nothing left to redact
+	if b.redactor != nil {
+		if counts := b.redactor.RedactRequest(built.request); len(counts) > 0 {
+			built.redactions = counts
+		}
+	}
+
+	built.promptHash, err = hashRequest(built.request)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return built, nil
+}
+
+// hashRequest returns a hash that identifies the exact request sent to a model
+func hashRequest(req *llm.Request) (string, error) {
+	const op = "scopes.hashRequest"
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error marshaling request", err)
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// readFile reads a file of the scope. Files deleted since the base commit are
+// read from the base commit, in that case deleted is true
+func (s *Scope) readFile(path string) (content []byte, deleted bool, err error) {
+	const op = "Scope.readFile"
+
+	content, err = os.ReadFile(path)
+	if err == nil {
+		return content, false, nil
+	}
+
+	if os.IsNotExist(err) && s.BaseCommit != "" {
+		removed, gitErr := git.ShowFile(s.BaseCommit, path)
+		if gitErr == nil {
+			return []byte(removed), true, nil
+		}
+	}
+
+	return nil, false, ez.New(op, ez.EINTERNAL, "Failed to read file: "+path, err)
+}
+
+// loadContext builds the shared context sent before the prompt of every file
+func (s *Scope) loadContext(paths []string, projectPolicy *policy.Policy) (string, error) {
+	const op = "Scope.loadContext"
+
+	if len(paths) == 0 {
+		return "", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("The following files are provided as context, they are not the file to work on.\n\n")
+
+	for _, path := range paths {
+		content, _, err := s.readFile(path)
+		if err != nil {
+			return "", ez.Wrap(op, err)
+		}
+
+		if err := projectPolicy.CheckFile(path, content); err != nil {
+			return "", ez.Wrap(op, err)
+		}
+
+		if files.IsBinaryFile(content) {
+			errMsg := fmt.Sprintf("Context file %s is a binary file", path)
+			return "", ez.New(op, ez.EINVALID, errMsg, nil)
+		}
+
+		fmt.Fprintf(&b, "Context File: %s\n%s\n\n", path, string(content))
+	}
+
+	return b.String(), nil
+}
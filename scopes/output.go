@@ -0,0 +1,155 @@
+package scopes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/ez"
+)
+
+// DefaultOutName is the default template of the name of saved responses
+const DefaultOutName = "{{.Name}}.llm.md"
+
+const indexFile = "index.md"
+
+// OutNameData holds the values available to the output name template
+type OutNameData struct {
+	Path  string // Path of the file, e.g. pkg/api/server.go
+	Dir   string // Directory of the file, e.g. pkg/api
+	Name  string // Name of the file, e.g. server.go
+	Stem  string // Name without extension, e.g. server
+	Ext   string // Extension, e.g. .go
+	RunID string
+	Model string
+}
+
+// OutDir returns the directory where the responses of the run are saved
+func (r *Run) OutDir() string {
+	if r.Options.OutDir != "" {
+		return r.Options.OutDir
+	}
+	return files.GetOutDirPath(r.ID)
+}
+
+// OutputPath returns where the response of a file is saved, mirroring the
+// scope inside the output directory. Outputs of other runs are never
+// overwritten, a numbered name is used instead
+func (r *Run) OutputPath(file *RunFile) (string, error) {
+	const op = "Run.OutputPath"
+
+	name := r.Options.OutName
+	if name == "" {
+		name = DefaultOutName
+	}
+
+	tmpl, err := template.New("out-name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", ez.New(op, ez.EINVALID, "Failed to parse the output name template", err)
+	}
+
+	base := filepath.Base(file.Path)
+	data := &OutNameData{
+		Path:  file.Path,
+		Dir:   filepath.Dir(file.Path),
+		Name:  base,
+		Stem:  strings.TrimSuffix(base, filepath.Ext(base)),
+		Ext:   filepath.Ext(base),
+		RunID: r.ID,
+		Model: r.Options.Model,
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", ez.New(op, ez.EINVALID, "Failed to render the output name template", err)
+	}
+
+	relPath := filepath.Clean(filepath.Join(data.Dir, rendered.String()))
+	if relPath == "." || strings.HasPrefix(relPath, "..") || filepath.IsAbs(relPath) {
+		errMsg := fmt.Sprintf("The output name %s of %s is outside of the output directory", rendered.String(), file.Path)
+		return "", ez.New(op, ez.EINVALID, errMsg, nil)
+	}
+
+	return r.unusedPath(filepath.Join(r.OutDir(), relPath), file.Output), nil
+}
+
+// ReportPath returns where the reduced report of the run is saved
+func (r *Run) ReportPath() string {
+	return r.unusedPath(filepath.Join(r.OutDir(), "report.md"), r.Report)
+}
+
+// unusedPath returns path, or a numbered variant of it if it already exists
+// and it isn't the output of this run
+func (r *Run) unusedPath(path, current string) string {
+	ext := filepath.Ext(path)
+	candidate := path
+	for i := 2; ; i++ {
+		if candidate == current {
+			return candidate
+		}
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i, ext)
+	}
+}
+
+// WriteOutput saves content to path, creating its directories
+func (r *Run) WriteOutput(path, content string) error {
+	const op = "Run.WriteOutput"
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error creating output directory", err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Failed to write output file: "+path, err)
+	}
+
+	return nil
+}
+
+// WriteIndex writes the index of the outputs of the run to the output
+// directory and returns its path
+func (r *Run) WriteIndex() (string, error) {
+	const op = "Run.WriteIndex"
+
+	header := fmt.Sprintf("# Outputs of run %s\n", r.ID)
+
+	// The output directory can be shared between runs, keep their indexes
+	path := filepath.Join(r.OutDir(), indexFile)
+	if existing, err := os.ReadFile(path); err == nil && !strings.HasPrefix(string(existing), header) {
+		path = filepath.Join(r.OutDir(), fmt.Sprintf("index-%s.md", r.ID))
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	fmt.Fprintf(&b, "\nScope `%s`, model `%s`, commit `%s`\n\n", r.Scope.Name, r.Options.Model, r.Commit)
+
+	if r.Report != "" {
+		fmt.Fprintf(&b, "- Report: [%s](%s)\n", filepath.Base(r.Report), r.relativeToOutDir(r.Report))
+	}
+
+	for _, file := range r.Files {
+		if file.Output != "" {
+			fmt.Fprintf(&b, "- %s: [%s](%s)\n", file.Path, r.relativeToOutDir(file.Output), r.relativeToOutDir(file.Output))
+		}
+	}
+
+	if err := r.WriteOutput(path, b.String()); err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	return path, nil
+}
+
+func (r *Run) relativeToOutDir(path string) string {
+	rel, err := filepath.Rel(r.OutDir(), path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
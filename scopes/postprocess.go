@@ -0,0 +1,63 @@
+package scopes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/vanclief/coderunner/extract"
+	"github.com/vanclief/ez"
+)
+
+// postProcess applies the extracts and then the shell filters of a run to the
+// response of a file, in the order they were given
+func postProcess(options *RunOptions, path, response string) (string, error) {
+	const op = "scopes.postProcess"
+
+	for _, spec := range options.Extract {
+		extracted, err := extract.Apply(spec, response)
+		if err != nil {
+			errMsg := fmt.Sprintf("--extract %s: %s", spec, ez.ErrorMessage(err))
+			return "", ez.Root(op, ez.ENOTFOUND, errMsg)
+		}
+		response = extracted
+	}
+
+	for _, filter := range options.Filters {
+		filtered, err := runFilter(filter, path, response)
+		if err != nil {
+			return "", ez.Wrap(op, err)
+		}
+		response = filtered
+	}
+
+	return response, nil
+}
+
+// runFilter pipes a response through a shell command, the path of the file is
+// available in $CODERUNNER_FILE
+func runFilter(filter, path, response string) (string, error) {
+	const op = "scopes.runFilter"
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", filter)
+	cmd.Stdin = strings.NewReader(response)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "CODERUNNER_FILE="+path)
+
+	if err := cmd.Run(); err != nil {
+		errMsg := fmt.Sprintf("--filter %s failed (%s) %s", filter, err, strings.TrimSpace(stderr.String()))
+		return "", ez.Root(op, ez.EINVALID, strings.TrimSpace(errMsg))
+	}
+
+	if strings.TrimSpace(stdout.String()) == "" {
+		errMsg := fmt.Sprintf("--filter %s returned nothing", filter)
+		return "", ez.Root(op, ez.ENOTFOUND, errMsg)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,225 @@
+package scopes
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-isatty"
+	"github.com/vanclief/coderunner/llm"
+)
+
+// progressInterval is how often the live progress line is redrawn
+const progressInterval = 250 * time.Millisecond
+
+// progress reports the files of a run as they are processed. On a terminal it
+// keeps a live status line, otherwise it logs a line per event
+type progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	live    bool
+	model   *llm.Model
+	started time.Time
+
+	total     int
+	completed int
+	failed    int
+	usage     llm.Usage
+	active    map[string]time.Time
+	lineShown bool
+	suspended int // Callbacks printing output, the line is hidden until they resume
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newProgress starts reporting the progress of the given amount of files
+func newProgress(run *Run, total int) *progress {
+	model, _ := llm.LookupModel(run.Options.Model)
+
+	p := &progress{
+		out:     os.Stderr,
+		live:    isatty.IsTerminal(os.Stdout.Fd()) && isatty.IsTerminal(os.Stderr.Fd()),
+		model:   model,
+		started: time.Now(),
+		total:   total,
+		usage:   run.TotalUsage(),
+		active:  make(map[string]time.Time),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if p.live {
+		go p.refresh()
+	} else {
+		close(p.done)
+	}
+
+	return p
+}
+
+// refresh redraws the status line until the progress is closed so the elapsed
+// time keeps moving while waiting for the LLM
+func (p *progress) refresh() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// start records that the LLM is being called for a file
+func (p *progress) start(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active[path] = time.Now()
+
+	if p.live {
+		p.draw()
+	} else {
+		fmt.Fprintf(p.out, "Calling LLM for %s\n", path)
+	}
+}
+
+// finish records the result of a file
+func (p *progress) finish(file *RunFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.active, file.Path)
+	p.completed++
+	p.usage.Add(file.Usage)
+
+	var line string
+	switch file.Status {
+	case FileFailed:
+		p.failed++
+		line = fmt.Sprintf("[%d/%d] Failed %s: %s", p.completed, p.total, file.Path, file.Error)
+	case FileSkipped:
+		line = fmt.Sprintf("[%d/%d] Skipped %s", p.completed, p.total, file.Path)
+	default:
+		line = fmt.Sprintf("[%d/%d] Done %s in %s, %d tokens", p.completed, p.total, file.Path,
+			time.Duration(file.DurationMs)*time.Millisecond, file.Usage.Total())
+	}
+
+	// On a terminal only failures are kept above the status line
+	if !p.live {
+		fmt.Fprintln(p.out, line)
+	} else if file.Status == FileFailed {
+		p.clear()
+		fmt.Fprintln(p.out, line)
+		p.draw()
+	}
+}
+
+// suspend clears the status line and stops redrawing it so other output can be
+// printed, it must be followed by resume. Files keep being reported meanwhile
+func (p *progress) suspend() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.suspended++
+	p.clear()
+}
+
+// resume redraws the status line after suspend
+func (p *progress) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.suspended--
+	p.draw()
+}
+
+// close stops the live status line and removes it
+func (p *progress) close() {
+	if p.live {
+		close(p.stop)
+	}
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+func (p *progress) clear() {
+	if p.lineShown {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.lineShown = false
+	}
+}
+
+func (p *progress) draw() {
+	if !p.live || p.suspended > 0 {
+		return
+	}
+
+	elapsed := time.Since(p.started)
+
+	parts := []string{fmt.Sprintf("[%d/%d]", p.completed, p.total)}
+	if p.failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", p.failed))
+	}
+
+	timing := formatDuration(elapsed)
+	if p.completed > 0 && p.completed < p.total {
+		eta := elapsed / time.Duration(p.completed) * time.Duration(p.total-p.completed)
+		timing += ", ETA " + formatDuration(eta)
+	}
+	parts = append(parts, timing)
+
+	tokens := fmt.Sprintf("%d tokens", p.usage.Total())
+	if p.model != nil {
+		tokens += fmt.Sprintf(", $%.4f", p.model.Cost(p.usage))
+	}
+	parts = append(parts, tokens)
+
+	if len(p.active) > 0 {
+		paths := make([]string, 0, len(p.active))
+		for path := range p.active {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		parts = append(parts, strings.Join(paths, ", "))
+	}
+
+	line := strings.Join(parts, " | ")
+	if width := terminalWidth(); utf8.RuneCountInString(line) > width-1 {
+		// Paths can hold multibyte characters, cut on a rune boundary
+		line = string([]rune(line)[:width-4]) + "..."
+	}
+
+	fmt.Fprint(p.out, "\r\033[K"+line)
+	p.lineShown = true
+}
+
+// terminalWidth returns the width of the terminal from $COLUMNS
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 20 {
+		return columns
+	}
+	return 100
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
@@ -0,0 +1,237 @@
+package scopes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/ez"
+)
+
+// MaxReduceChars is the maximum amount of per-file output sent in a single
+// reduce call, larger inputs are reduced hierarchically
+const MaxReduceChars = 200000
+
+// reduceEntry is a response (or an intermediate summary) waiting to be reduced
+type reduceEntry struct {
+	Path     string // File or directory the response belongs to
+	Label    string // Name shown to the model
+	Response string
+}
+
+// ReduceResponses synthesizes the per-file responses of a run into a single
+// report. If the responses do not fit in a single call they are first reduced
+// per directory and then combined. The usage of every call is recorded on the
+// run and its budget is checked before each of them
+func (s *Scope) ReduceResponses(api llm.API, run *Run) (string, error) {
+	const op = "Scope.ReduceResponses"
+
+	responses := run.Responses()
+	if len(responses) == 0 {
+		return "", ez.New(op, ez.EINVALID, "No responses to reduce", nil)
+	}
+
+	paths := make([]string, 0, len(responses))
+	for path := range responses {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	entries := make([]reduceEntry, 0, len(paths))
+	for _, path := range paths {
+		entries = append(entries, reduceEntry{
+			Path:     path,
+			Label:    path,
+			Response: Truncate(responses[path], MaxReduceChars/3),
+		})
+	}
+
+	report, err := reduceEntries(api, run, entries)
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	return report, nil
+}
+
+// reduceEntries reduces the entries into the final report, grouping them by
+// directory while they don't fit in a single call
+func reduceEntries(api llm.API, run *Run, entries []reduceEntry) (string, error) {
+	const op = "scopes.reduceEntries"
+
+	prompt := run.Options.ReducePrompt
+	if entriesSize(entries) <= MaxReduceChars {
+		return callReduce(api, run, buildReducePrompt(prompt, entries))
+	}
+
+	groups := groupByParent(entries)
+	chunked := false
+	if len(groups) == 1 {
+		// Everything is already in the same directory, split by size instead
+		groups = chunkBySize(groups[0])
+		chunked = true
+	}
+
+	partials := make([]reduceEntry, 0, len(groups))
+	for i, group := range groups {
+		if len(group) == 1 {
+			entry := group[0]
+			entry.Path = parentDir(entry.Path)
+			partials = append(partials, entry)
+			continue
+		}
+
+		dir := parentDir(group[0].Path)
+		label := dir
+		if chunked {
+			label = fmt.Sprintf("%s (part %d)", dir, i+1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Reducing %s... ", label)
+		summary, err := reducePartial(api, run, label, group)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed", err)
+			return "", ez.Wrap(op, err)
+		}
+		fmt.Fprintln(os.Stderr, "Ok")
+
+		partials = append(partials, reduceEntry{
+			Path:     dir,
+			Label:    label,
+			Response: Truncate(summary, MaxReduceChars/3),
+		})
+	}
+
+	return reduceEntries(api, run, partials)
+}
+
+// reducePartial summarizes a group of entries as an intermediate step
+func reducePartial(api llm.API, run *Run, label string, entries []reduceEntry) (string, error) {
+	prompt := run.Options.ReducePrompt
+
+	if entriesSize(entries) > MaxReduceChars {
+		// The group itself is too large, keep splitting it
+		summaries := make([]reduceEntry, 0)
+		for i, chunk := range chunkBySize(entries) {
+			chunkLabel := fmt.Sprintf("%s (part %d)", label, i+1)
+			summary, err := callReduce(api, run, buildPartialPrompt(prompt, chunkLabel, chunk))
+			if err != nil {
+				return "", err
+			}
+			summaries = append(summaries, reduceEntry{Path: entries[0].Path, Label: chunkLabel, Response: summary})
+		}
+		entries = summaries
+	}
+
+	return callReduce(api, run, buildPartialPrompt(prompt, label, entries))
+}
+
+// callReduce sends a reduce call if the run still has budget and records its
+// usage
+func callReduce(api llm.API, run *Run, prompt string) (string, error) {
+	const op = "scopes.callReduce"
+
+	if err := run.CheckBudget(); err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	response, err := api.Send(llm.NewRequest("", "", prompt))
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "LLM reduce call failed", err)
+	}
+	run.ReduceUsage.Add(response.Usage)
+
+	return response.Content, nil
+}
+
+func buildReducePrompt(prompt string, entries []reduceEntry) string {
+	return fmt.Sprintf("%s\n\nThe following are the results of running a prompt over each file of a codebase, synthesize them into a single report.\n\n%s",
+		prompt, formatEntries(entries))
+}
+
+func buildPartialPrompt(prompt, label string, entries []reduceEntry) string {
+	return fmt.Sprintf("The following are the results of running a prompt over the files in %s. "+
+		"Summarize them into an intermediate report that keeps every detail relevant to this final instruction, "+
+		"and reference the paths they come from:\n%s\n\n%s",
+		label, prompt, formatEntries(entries))
+}
+
+func formatEntries(entries []reduceEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "Path: %s\nResult:\n%s\n\n", entry.Label, entry.Response)
+	}
+	return b.String()
+}
+
+func entriesSize(entries []reduceEntry) int {
+	size := 0
+	for _, entry := range entries {
+		size += len(entry.Label) + len(entry.Response)
+	}
+	return size
+}
+
+// groupByParent groups the entries by their parent directory
+func groupByParent(entries []reduceEntry) [][]reduceEntry {
+	index := make(map[string]int)
+	groups := make([][]reduceEntry, 0)
+
+	for _, entry := range entries {
+		dir := parentDir(entry.Path)
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, make([]reduceEntry, 0))
+		}
+		groups[i] = append(groups[i], entry)
+	}
+
+	return groups
+}
+
+// chunkBySize splits the entries in groups that fit in a single call
+func chunkBySize(entries []reduceEntry) [][]reduceEntry {
+	chunks := make([][]reduceEntry, 0)
+	current := make([]reduceEntry, 0)
+	size := 0
+
+	for _, entry := range entries {
+		entrySize := len(entry.Label) + len(entry.Response)
+		if len(current) > 0 && size+entrySize > MaxReduceChars {
+			chunks = append(chunks, current)
+			current = make([]reduceEntry, 0)
+			size = 0
+		}
+		current = append(current, entry)
+		size += entrySize
+	}
+
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+func parentDir(path string) string {
+	return filepath.ToSlash(filepath.Dir(path))
+}
+
+// Truncate cuts a text to at most max bytes without splitting a UTF-8 rune and
+// marks it as truncated
+func Truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+
+	for max > 0 && !utf8.RuneStart(text[max]) {
+		max--
+	}
+	return text[:max] + "\n[truncated]"
+}
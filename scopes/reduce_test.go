@@ -0,0 +1,103 @@
+package scopes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkBySize(t *testing.T) {
+	entry := func(label string, size int) reduceEntry {
+		return reduceEntry{Path: label, Label: label, Response: strings.Repeat("x", size-len(label))}
+	}
+	half := MaxReduceChars / 2
+
+	tests := []struct {
+		name    string
+		entries []reduceEntry
+		want    [][]string
+	}{
+		{name: "empty", entries: nil, want: [][]string{}},
+		{name: "fits in one chunk", entries: []reduceEntry{entry("a", 10), entry("b", 10)}, want: [][]string{{"a", "b"}}},
+		{name: "exactly the limit", entries: []reduceEntry{entry("a", half), entry("b", half)}, want: [][]string{{"a", "b"}}},
+		{
+			name:    "splits past the limit",
+			entries: []reduceEntry{entry("a", half), entry("b", half), entry("c", 1)},
+			want:    [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:    "oversized entry gets its own chunk",
+			entries: []reduceEntry{entry("a", 10), entry("b", MaxReduceChars+1), entry("c", 10)},
+			want:    [][]string{{"a"}, {"b"}, {"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkBySize(tt.entries)
+
+			got := make([][]string, 0, len(chunks))
+			for _, chunk := range chunks {
+				labels := make([]string, 0, len(chunk))
+				for _, entry := range chunk {
+					labels = append(labels, entry.Label)
+				}
+				got = append(got, labels)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkBySize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupByParent(t *testing.T) {
+	entries := []reduceEntry{{Path: "a/x.go"}, {Path: "b/y.go"}, {Path: "a/z.go"}, {Path: "root.go"}}
+
+	groups := groupByParent(entries)
+
+	want := [][]string{{"a/x.go", "a/z.go"}, {"b/y.go"}, {"root.go"}}
+	got := make([][]string, 0, len(groups))
+	for _, group := range groups {
+		paths := make([]string, 0, len(group))
+		for _, entry := range group {
+			paths = append(paths, entry.Path)
+		}
+		got = append(got, paths)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByParent() = %q, want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{name: "short", text: "hello", max: 10, want: "hello"},
+		{name: "exact", text: "hello", max: 5, want: "hello"},
+		{name: "ascii", text: "hello world", max: 5, want: "hello\n[truncated]"},
+		{name: "inside a rune", text: "añb", max: 2, want: "a\n[truncated]"},
+		{name: "after a rune", text: "añb", max: 3, want: "añ\n[truncated]"},
+		{name: "inside a four byte rune", text: "😀😀", max: 6, want: "😀\n[truncated]"},
+		{name: "zero", text: "abc", max: 0, want: "\n[truncated]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.text, tt.max)
+			if got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Truncate(%q, %d) = %q is not valid UTF-8", tt.text, tt.max, got)
+			}
+		})
+	}
+}
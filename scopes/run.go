@@ -0,0 +1,401 @@
+package scopes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/llm"
+	"github.com/vanclief/coderunner/redact"
+	"github.com/vanclief/ez"
+)
+
+const checkpointFile = "checkpoint.json"
+
+const (
+	ModePrompt = ""
+	ModeApply  = "apply"
+	ModePatch  = "patch"
+	ModeTests  = "tests"
+)
+
+const (
+	PatchApply = "apply"
+	PatchStage = "stage"
+	PatchSave  = "save"
+)
+
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "markdown"
+	FormatSARIF    = "sarif"
+)
+
+// FileStatus is the processing status of a file inside a run
+type FileStatus string
+
+const (
+	FilePending FileStatus = "pending"
+	FileDone    FileStatus = "done"
+	FileFailed  FileStatus = "failed"
+	FileSkipped FileStatus = "skipped"
+)
+
+// RunOptions holds the parameters of a run, they are persisted so the run can
+// be resumed with the same parameters
+type RunOptions struct {
+	Prompt         string   `json:"prompt"`
+	PromptName     string   `json:"promptName,omitempty"`
+	TemplateFile   string   `json:"templateFile,omitempty"`
+	System         string   `json:"system,omitempty"`
+	ContextFiles   []string `json:"contextFiles,omitempty"`
+	Model          string   `json:"model"`
+	Save           bool     `json:"save"`
+	ReducePrompt   string   `json:"reducePrompt,omitempty"`
+	KeepGoing      bool     `json:"keepGoing"`
+	Mode           string   `json:"mode,omitempty"`
+	PatchAction    string   `json:"patchAction,omitempty"`
+	PatchRetries   int      `json:"patchRetries,omitempty"`
+	Diff           bool     `json:"diff,omitempty"`
+	DiffContext    int      `json:"diffContext"`
+	DiffFull       bool     `json:"diffFull,omitempty"`
+	Budget         float64  `json:"budget,omitempty"`
+	MaxTokensTotal int      `json:"maxTokensTotal,omitempty"`
+	Format         string   `json:"format,omitempty"`
+	FixRetries     int      `json:"fixRetries,omitempty"`
+	OutDir         string   `json:"outDir,omitempty"`  // Defaults to .coderunner/out/<run id>
+	OutName        string   `json:"outName,omitempty"` // Template of the name of saved responses
+	Concurrency    int      `json:"concurrency,omitempty"`
+	Comparison     string   `json:"comparison,omitempty"` // ID shared by the runs of a model comparison
+	Extract        []string `json:"extract,omitempty"`    // Parts of the responses kept, e.g. code:go or json
+	Filters        []string `json:"filters,omitempty"`    // Shell commands the responses are piped through
+	Include        []string `json:"include,omitempty"`    // Globs of the files of the scope to run on
+	Exclude        []string `json:"exclude,omitempty"`    // Globs of the files of the scope to skip
+	ChangedSince   string   `json:"changedSince,omitempty"`
+	NoRedact       bool     `json:"noRedact,omitempty"` // Send secrets to the provider as they are
+}
+
+// RunFile holds the status and the result of a single file of a run
+type RunFile struct {
+	Path        string         `json:"path"`
+	Status      FileStatus     `json:"status"`
+	PromptHash  string         `json:"promptHash,omitempty"`
+	ContentHash string         `json:"contentHash,omitempty"`
+	Model       string         `json:"model,omitempty"`
+	Response    string         `json:"response,omitempty"`
+	Usage       llm.Usage      `json:"usage"`
+	StartedAt   time.Time      `json:"startedAt,omitempty"`
+	DurationMs  int64          `json:"durationMs,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Output      string         `json:"output,omitempty"`     // Path where the response was saved
+	Redactions  map[string]int `json:"redactions,omitempty"` // Secrets redacted from the request by detector
+	Truncated   bool           `json:"truncated,omitempty"`  // The response was cut at the max output tokens
+
+	// Request is the request sent for the file, it is only kept in memory
+	Request *llm.Request `json:"-"`
+}
+
+// Run is a prompt being executed over the files of a scope, it is checkpointed
+// after every file so it can be resumed
+type Run struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Commit    string     `json:"commit"`
+	Scope     *Scope     `json:"scope"`
+	Options   RunOptions `json:"options"`
+	Files     []*RunFile `json:"files"`
+	Report    string     `json:"report,omitempty"` // Path where the reduced report was saved
+
+	ReduceUsage llm.Usage `json:"reduceUsage"` // Tokens consumed by the reduce calls
+
+	redactor *redact.Redactor
+}
+
+// NewRun creates a new run with every file of the scope pending
+func NewRun(scope *Scope, options RunOptions) (*Run, error) {
+	const op = "scopes.NewRun"
+
+	id, err := newRunID()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	gitInfo, err := git.GetInfo()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	paths, err := options.filterPaths(scope.GetAllFilePaths())
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+	sort.Strings(paths)
+
+	run := &Run{
+		ID:        id,
+		CreatedAt: time.Now(),
+		Commit:    gitInfo.CurrentCommit,
+		Scope:     scope,
+		Options:   options,
+		Files:     make([]*RunFile, 0, len(paths)),
+	}
+
+	// Context files are sent with every prompt but never processed
+	context := make(map[string]bool)
+	for _, path := range run.contextFiles() {
+		context[filepath.Clean(path)] = true
+	}
+
+	for _, path := range paths {
+		if context[filepath.Clean(path)] {
+			continue
+		}
+		run.Files = append(run.Files, &RunFile{Path: path, Status: FilePending})
+	}
+
+	return run, nil
+}
+
+// LoadRun loads a run from its checkpoint file
+func LoadRun(id string) (*Run, error) {
+	const op = "scopes.LoadRun"
+
+	data, err := os.ReadFile(filepath.Join(files.GetRunDirPath(id), checkpointFile))
+	if err != nil {
+		errMsg := fmt.Sprintf("Run %s doesn't exist", id)
+		return nil, ez.New(op, ez.ENOTFOUND, errMsg, err)
+	}
+
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Failed to parse run checkpoint file", err)
+	}
+
+	return &run, nil
+}
+
+// Save writes the checkpoint file of the run
+func (r *Run) Save() error {
+	const op = "Run.Save"
+
+	dirPath := files.GetRunDirPath(r.ID)
+	if err := files.EnsureDirectoryExists(dirPath); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error creating run directory", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error marshaling run", err)
+	}
+
+	// Write to a temporary file first so an interrupted run never leaves a
+	// corrupted checkpoint behind
+	filePath := filepath.Join(dirPath, checkpointFile)
+	tmpPath := filePath + ".tmp"
+
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing run checkpoint file", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error writing run checkpoint file", err)
+	}
+
+	return nil
+}
+
+// Redactor returns the redactor shared by every request of the run, so the
+// placeholders of any response can be restored. It is nil with NoRedact
+func (r *Run) Redactor() (*redact.Redactor, error) {
+	const op = "Run.Redactor"
+
+	if r.Options.NoRedact || r.redactor != nil {
+		return r.redactor, nil
+	}
+
+	redactor, err := NewRedactor()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+	r.redactor = redactor
+
+	return redactor, nil
+}
+
+// ReadContent returns the content of a file as it was sent to the model, files
+// deleted since the base commit are read from it. It fails if the file changed
+// since its request was built
+func (r *Run) ReadContent(file *RunFile) ([]byte, error) {
+	const op = "Run.ReadContent"
+
+	content, _, err := r.Scope.readFile(file.Path)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	hash := sha256.Sum256(content)
+	if hex.EncodeToString(hash[:]) != file.ContentHash {
+		errMsg := fmt.Sprintf("%s changed since it was sent to the model", file.Path)
+		return nil, ez.Root(op, ez.ECONFLICT, errMsg)
+	}
+
+	return content, nil
+}
+
+// contextFiles returns the context files of the scope and the run
+func (r *Run) contextFiles() []string {
+	paths := make([]string, 0, len(r.Scope.ContextFiles)+len(r.Options.ContextFiles))
+	seen := make(map[string]bool)
+
+	for _, path := range append(append([]string{}, r.Scope.ContextFiles...), r.Options.ContextFiles...) {
+		if !seen[filepath.Clean(path)] {
+			seen[filepath.Clean(path)] = true
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// ResetFailed marks the failed files as pending so they are retried
+func (r *Run) ResetFailed() {
+	for _, file := range r.Files {
+		if file.Status == FileFailed {
+			file.Status = FilePending
+			file.Error = ""
+		}
+	}
+}
+
+// Responses returns the responses of the files that were processed
+func (r *Run) Responses() map[string]string {
+	responses := make(map[string]string)
+	for _, file := range r.Files {
+		if file.Status == FileDone {
+			responses[file.Path] = file.Response
+		}
+	}
+	return responses
+}
+
+// TotalUsage returns the tokens consumed by every file and reduce call of the
+// run
+func (r *Run) TotalUsage() llm.Usage {
+	usage := r.ReduceUsage
+	for _, file := range r.Files {
+		usage.Add(file.Usage)
+	}
+	return usage
+}
+
+// GetFile returns the file of the run with the given path
+func (r *Run) GetFile(path string) (*RunFile, error) {
+	const op = "Run.GetFile"
+
+	for _, file := range r.Files {
+		if file.Path == path {
+			return file, nil
+		}
+	}
+
+	errMsg := fmt.Sprintf("File %s is not part of run %s", path, r.ID)
+	return nil, ez.New(op, ez.ENOTFOUND, errMsg, nil)
+}
+
+// Count returns the amount of files with the given status
+func (r *Run) Count(status FileStatus) int {
+	count := 0
+	for _, file := range r.Files {
+		if file.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// IsFinished returns true if there are no pending or failed files
+func (r *Run) IsFinished() bool {
+	return r.Count(FilePending) == 0 && r.Count(FileFailed) == 0
+}
+
+// PrintSummary prints the successes and failures of the run to stderr
+func (r *Run) PrintSummary() {
+	fmt.Fprintf(os.Stderr, "\nRun %s: %d done, %d failed, %d skipped, %d pending\n",
+		r.ID, r.Count(FileDone), r.Count(FileFailed), r.Count(FileSkipped), r.Count(FilePending))
+
+	for _, file := range r.Files {
+		if file.Status == FileFailed {
+			fmt.Fprintf(os.Stderr, "  Failed %s: %s\n", file.Path, file.Error)
+		}
+	}
+
+	for _, file := range r.Files {
+		if len(file.Redactions) > 0 {
+			fmt.Fprintf(os.Stderr, "  Redacted %s: %s\n", file.Path, redact.Summary(file.Redactions))
+		}
+	}
+
+	if r.HasBudget() {
+		fmt.Fprintf(os.Stderr, "Spent: $%.4f, %d tokens\n", r.Spent(), r.TotalUsage().Total())
+	}
+
+	if !r.IsFinished() {
+		fmt.Fprintf(os.Stderr, "Resume with: coderunner llm resume %s\n", r.ID)
+	}
+}
+
+// ListRuns loads every persisted run, the most recent first
+func ListRuns() ([]*Run, error) {
+	const op = "scopes.ListRuns"
+
+	runsDir := filepath.Join(files.CODERUNNER_DIR, files.RUNS_DIR)
+
+	entries, err := os.ReadDir(runsDir)
+	if os.IsNotExist(err) {
+		return []*Run{}, nil
+	} else if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error reading runs directory", err)
+	}
+
+	runs := make([]*Run, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		run, err := LoadRun(entry.Name())
+		if err != nil {
+			// Ignore runs that were interrupted before their first checkpoint
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+
+	return runs, nil
+}
+
+// newRunID creates a sortable and unique run identifier
+func newRunID() (string, error) {
+	const op = "scopes.newRunID"
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error generating run ID", err)
+	}
+
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix)), nil
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/policy"
 	"github.com/vanclief/ez"
 )
 
@@ -17,6 +18,7 @@ type Scope struct {
 	Name         string                 `json:"name"`
 	BaseCommit   string                 `json:"baseCommit"`
 	TargetCommit string                 `json:"targetCommit,omitempty"`
+	ContextFiles []string               `json:"contextFiles,omitempty"` // Files sent as context with every prompt
 	Files        map[string]interface{} `json:"files"`
 }
 
@@ -216,6 +218,11 @@ func hasInScopeFiles(fileMap map[string]interface{}) bool {
 func (s *Scope) GetFilesContent() (map[string]string, error) {
 	const op = "Scope.GetFilesContent"
 
+	projectPolicy, err := policy.Load()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
 	contents := make(map[string]string)
 	paths := make([]string, 0)
 
@@ -224,14 +231,18 @@ func (s *Scope) GetFilesContent() (map[string]string, error) {
 
 	// Then read each file
 	for _, path := range paths {
-		content, err := os.ReadFile(path)
+		content, _, err := s.readFile(path)
 		if err != nil {
-			return nil, ez.New(op, ez.EINTERNAL, "Failed to read file: "+path, err)
+			return nil, ez.Wrap(op, err)
 		}
 
 		if files.IsBinaryFile(content) {
 			continue // Skip binary files
 		}
+
+		if err := projectPolicy.CheckFile(path, content); err != nil {
+			return nil, ez.Wrap(op, err)
+		}
 		contents[path] = string(content)
 	}
 
@@ -0,0 +1,184 @@
+package scopes
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/vanclief/coderunner/files"
+	"github.com/vanclief/coderunner/git"
+	"github.com/vanclief/coderunner/policy"
+	"github.com/vanclief/ez"
+)
+
+// DefaultDiffContext is the amount of context lines included in diffs
+const DefaultDiffContext = 3
+
+// PromptData holds the variables available to a prompt template
+type PromptData struct {
+	Path         string
+	Content      string
+	Language     string
+	Deleted      bool // The file was deleted, Content holds the removed content
+	Scope        *Scope
+	BaseCommit   string
+	TargetCommit string
+
+	diffContext int
+	diff        *string
+}
+
+// Diff returns the diff of the file in the scope. For scopes created with a
+// base commit it compares the base against the target, otherwise it compares
+// the target against the working tree
+func (d *PromptData) Diff() (string, error) {
+	if d.diff != nil {
+		return *d.diff, nil
+	}
+
+	var diff string
+	var err error
+
+	if d.BaseCommit != "" {
+		diff, err = git.GetFileDiff(d.BaseCommit, d.TargetCommit, d.Path, d.diffContext)
+	} else {
+		diff, err = git.GetFileDiff(d.TargetCommit, "", d.Path, d.diffContext)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	d.diff = &diff
+	return diff, nil
+}
+
+// DiffOptions configures the diff mode, where the prompt includes the changes
+// of each file instead of only its content
+type DiffOptions struct {
+	Context int  // Lines of context around each hunk
+	Full    bool // Include the full new file next to the diff
+}
+
+// PromptTemplate renders the prompt sent for each file
+type PromptTemplate struct {
+	text     string
+	template *template.Template
+	diff     *DiffOptions
+}
+
+// NewPromptTemplate parses a prompt as a Go text/template. Prompts without
+// actions keep the original behavior of appending the file content at the end.
+// Files included by the template must be allowed by the policy
+func NewPromptTemplate(text string, projectPolicy *policy.Policy) (*PromptTemplate, error) {
+	const op = "scopes.NewPromptTemplate"
+
+	t := &PromptTemplate{text: text}
+
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+
+	tmpl, err := template.New("prompt").Funcs(templateFuncs(projectPolicy)).Parse(text)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Invalid prompt template: "+err.Error(), err)
+	}
+	t.template = tmpl
+
+	return t, nil
+}
+
+// SetDiff enables the diff mode for prompts without template actions
+func (t *PromptTemplate) SetDiff(options *DiffOptions) {
+	t.diff = options
+}
+
+// Render builds the prompt for a file
+func (t *PromptTemplate) Render(data *PromptData) (string, error) {
+	const op = "PromptTemplate.Render"
+
+	if t.template != nil {
+		var b strings.Builder
+		if err := t.template.Execute(&b, data); err != nil {
+			return "", ez.New(op, ez.EINVALID, "Failed to render prompt template for "+data.Path, err)
+		}
+
+		return b.String(), nil
+	}
+
+	if t.diff == nil {
+		if data.Deleted {
+			return fmt.Sprintf("%s\n\nThe file %s was deleted, removed content:\n%s", t.text, data.Path, data.Content), nil
+		}
+		return fmt.Sprintf("%s\n\nFile Content:\n%s", t.text, data.Content), nil
+	}
+
+	diff, err := data.Diff()
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nFile: %s\n", t.text, data.Path)
+
+	if data.Deleted {
+		fmt.Fprintf(&b, "\nThe file was deleted, removed content:\n%s", data.Content)
+		return b.String(), nil
+	}
+
+	fmt.Fprintf(&b, "\nDiff:\n%s", diff)
+	if t.diff.Full {
+		fmt.Fprintf(&b, "\n\nFull File Content:\n%s", data.Content)
+	}
+
+	return b.String(), nil
+}
+
+// newPromptData creates the template variables of a file of the scope
+func (s *Scope) newPromptData(path, content string, deleted bool, diffContext int) *PromptData {
+	return &PromptData{
+		Path:         path,
+		Content:      content,
+		Language:     files.DetectLanguage(path),
+		Deleted:      deleted,
+		Scope:        s,
+		BaseCommit:   s.BaseCommit,
+		TargetCommit: s.TargetCommit,
+		diffContext:  diffContext,
+	}
+}
+
+// templateFuncs returns the helper functions available to prompt templates
+func templateFuncs(projectPolicy *policy.Policy) template.FuncMap {
+	return template.FuncMap{
+		// readFile includes the content of another file of the repository
+		"readFile": func(path string) (string, error) {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			if err := projectPolicy.CheckFile(path, content); err != nil {
+				return "", fmt.Errorf("%s", ez.ErrorMessage(err))
+			}
+			return string(content), nil
+		},
+		// lines returns the lines from start to end (1-based, inclusive)
+		"lines": func(start, end int, text string) string {
+			all := strings.Split(text, "\n")
+			if start < 1 {
+				start = 1
+			}
+			if end > len(all) {
+				end = len(all)
+			}
+			if start > end {
+				return ""
+			}
+			return strings.Join(all[start-1:end], "\n")
+		},
+		// truncate limits the text to a maximum amount of characters
+		"truncate": func(max int, text string) string {
+			return Truncate(text, max)
+		},
+	}
+}